@@ -0,0 +1,55 @@
+package main
+
+import (
+	"compress/zlib"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	csd "github.com/toravir/csd/libs"
+)
+
+// encodeMain implements `csd encode`, converting newline delimited JSON to
+// CBOR.
+func encodeMain(args []string) {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	inFile := fs.String("in", "<stdin>", "Input File (newline delimited JSON)")
+	outFile := fs.String("out", "<stdout>", "Output File to which CBOR will be written to (WILL overwrite if already present).")
+	compressOut := fs.Bool("compress", false, "zlib compress the output stream")
+	times := fs.Bool("times", false, "Encode RFC3339 time strings as timestamps (tag 1)")
+	netAddrs := fs.Bool("net", false, "Encode IP, MAC and CIDR strings as network addresses and prefixes (tags 260 and 261)")
+	fs.Parse(args)
+
+	opts := csd.EncoderOptions{TimeStringsAsTags: *times, NetworkStringsAsTags: *netAddrs}
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stdout
+	if *inFile != "<stdin>" {
+		f, err := os.Open(*inFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		in = f
+		defer f.Close()
+	}
+	if *outFile != "<stdout>" {
+		f, err := os.OpenFile(*outFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out = f
+		defer f.Close()
+	}
+	if *compressOut {
+		zout := zlib.NewWriter(out)
+		out = zout
+		defer func() {
+			if err := zout.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if err := csd.Json2CborManyObjects(in, out, opts); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,235 @@
+package csd
+
+// This file contains the low level helpers appending encoded data items
+// to a byte slice.
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"time"
+)
+
+// AppendUint appends an unsigned integer.
+func AppendUint(dst []byte, v uint64) []byte {
+	return appendCborTypePrefix(dst, majorTypeUnsignedInt, v)
+}
+
+// AppendInt appends an integer.
+func AppendInt(dst []byte, v int64) []byte {
+	if v < 0 {
+		return appendCborTypePrefix(dst, majorTypeNegativeInt, uint64(-1-v))
+	}
+	return appendCborTypePrefix(dst, majorTypeUnsignedInt, uint64(v))
+}
+
+// AppendBigInt appends n as an integer if it fits in 64 bits, otherwise
+// as a bignum (tag 2 or 3).
+func AppendBigInt(dst []byte, n *big.Int) []byte {
+	if n.Sign() >= 0 {
+		if n.IsUint64() {
+			return AppendUint(dst, n.Uint64())
+		}
+		dst = AppendTag(dst, additionalTypeTagPositiveBignum)
+		return AppendBytes(dst, n.Bytes())
+	}
+	// Negative integers encode -1-n.
+	m := new(big.Int).Neg(n)
+	m.Sub(m, big.NewInt(1))
+	if m.IsUint64() {
+		return appendCborTypePrefix(dst, majorTypeNegativeInt, m.Uint64())
+	}
+	dst = AppendTag(dst, additionalTypeTagNegativeBignum)
+	return AppendBytes(dst, m.Bytes())
+}
+
+// AppendFloat32 appends a single precision float.
+func AppendFloat32(dst []byte, v float32) []byte {
+	switch {
+	case math.IsNaN(float64(v)):
+		return append(dst, float32Nan...)
+	case math.IsInf(float64(v), 1):
+		return append(dst, float32PosInfinity...)
+	case math.IsInf(float64(v), -1):
+		return append(dst, float32NegInfinity...)
+	}
+	b := math.Float32bits(v)
+	return append(dst, majorTypeSimpleAndFloat|additionalTypeFloat32,
+		byte(b>>24), byte(b>>16), byte(b>>8), byte(b))
+}
+
+// AppendFloat64 appends a double precision float.
+func AppendFloat64(dst []byte, v float64) []byte {
+	switch {
+	case math.IsNaN(v):
+		return append(dst, float64Nan...)
+	case math.IsInf(v, 1):
+		return append(dst, float64PosInfinity...)
+	case math.IsInf(v, -1):
+		return append(dst, float64NegInfinity...)
+	}
+	b := math.Float64bits(v)
+	return append(dst, majorTypeSimpleAndFloat|additionalTypeFloat64,
+		byte(b>>56), byte(b>>48), byte(b>>40), byte(b>>32), byte(b>>24), byte(b>>16), byte(b>>8), byte(b))
+}
+
+// AppendBool appends true or false.
+func AppendBool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, majorTypeSimpleAndFloat|additionalTypeBoolTrue)
+	}
+	return append(dst, majorTypeSimpleAndFloat|additionalTypeBoolFalse)
+}
+
+// AppendNull appends null.
+func AppendNull(dst []byte) []byte {
+	return append(dst, majorTypeSimpleAndFloat|additionalTypeNull)
+}
+
+// AppendUndefined appends undefined.
+func AppendUndefined(dst []byte) []byte {
+	return append(dst, majorTypeSimpleAndFloat|additionalTypeUndefined)
+}
+
+// AppendString appends a text string.
+func AppendString(dst []byte, s string) []byte {
+	dst = appendCborTypePrefix(dst, majorTypeUtf8String, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// AppendBytes appends a byte string.
+func AppendBytes(dst []byte, b []byte) []byte {
+	dst = appendCborTypePrefix(dst, majorTypeByteString, uint64(len(b)))
+	return append(dst, b...)
+}
+
+// AppendArrayHeader appends the head of an array of n elements, which
+// must follow.
+func AppendArrayHeader(dst []byte, n int) []byte {
+	return appendCborTypePrefix(dst, majorTypeArray, uint64(n))
+}
+
+// AppendMapHeader appends the head of a map of n pairs, whose keys and
+// values must follow.
+func AppendMapHeader(dst []byte, n int) []byte {
+	return appendCborTypePrefix(dst, majorTypeMap, uint64(n))
+}
+
+// AppendArrayStart appends the head of an indefinite length array, which
+// is ended by AppendBreak.
+func AppendArrayStart(dst []byte) []byte {
+	return append(dst, majorTypeArray|additionalTypeInfiniteCount)
+}
+
+// AppendMapStart appends the head of an indefinite length map, which is
+// ended by AppendBreak.
+func AppendMapStart(dst []byte) []byte {
+	return append(dst, majorTypeMap|additionalTypeInfiniteCount)
+}
+
+// AppendBreak appends the break code ending an indefinite length item.
+func AppendBreak(dst []byte) []byte {
+	return append(dst, majorTypeSimpleAndFloat|additionalTypeBreak)
+}
+
+// AppendTag appends a tag number. The tag content must follow.
+func AppendTag(dst []byte, number uint64) []byte {
+	return appendCborTypePrefix(dst, majorTypeTags, number)
+}
+
+// AppendTime appends t as an epoch based timestamp (tag 1) - an integer
+// for whole seconds, a float otherwise.
+func AppendTime(dst []byte, t time.Time) []byte {
+	dst = AppendTag(dst, additionalTypeTimestamp)
+	if t.Nanosecond() == 0 {
+		return AppendInt(dst, t.Unix())
+	}
+	return AppendFloat64(dst, float64(t.Unix())+float64(t.Nanosecond())/1e9)
+}
+
+// AppendDecimal appends a decimal fraction (tag 4).
+func AppendDecimal(dst []byte, d Decimal) []byte {
+	dst = AppendTag(dst, additionalTypeTagDecimalFraction)
+	dst = AppendArrayHeader(dst, 2)
+	dst = AppendInt(dst, d.Exponent)
+	if d.Mantissa == nil {
+		return AppendInt(dst, 0)
+	}
+	return AppendBigInt(dst, d.Mantissa)
+}
+
+// AppendBigFloat appends f as a bigfloat (tag 5). Infinities can not be
+// represented and return an error.
+func AppendBigFloat(dst []byte, f *big.Float) ([]byte, error) {
+	if f.IsInf() {
+		return nil, fmt.Errorf("csd: can not encode %v as a bigfloat", f)
+	}
+	// f = m * 2^exp with 0.5 <= |m| < 1, so f * 2^(prec-exp) is the
+	// integer mantissa when prec is the number of significant bits.
+	prec := int(f.MinPrec())
+	exp := f.MantExp(nil)
+	mant, _ := new(big.Float).SetMantExp(f, prec-exp).Int(nil)
+	if f.Sign() == 0 {
+		exp, prec = 0, 0
+	}
+	dst = AppendTag(dst, additionalTypeTagBigfloat)
+	dst = AppendArrayHeader(dst, 2)
+	dst = AppendInt(dst, int64(exp-prec))
+	return AppendBigInt(dst, mant), nil
+}
+
+// AppendIP appends a network address (tag 260). IPv4 addresses take 4
+// bytes.
+func AppendIP(dst []byte, ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	dst = AppendTag(dst, additionalTypeTagNetworkAddr)
+	return AppendBytes(dst, ip)
+}
+
+// AppendMAC appends a MAC address as a network address (tag 260).
+func AppendMAC(dst []byte, mac net.HardwareAddr) []byte {
+	dst = AppendTag(dst, additionalTypeTagNetworkAddr)
+	return AppendBytes(dst, mac)
+}
+
+// AppendIPNet appends a network prefix (tag 261) - a map of the address
+// to the prefix length.
+func AppendIPNet(dst []byte, pfx net.IPNet) []byte {
+	ip := pfx.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	ones, _ := pfx.Mask.Size()
+	dst = AppendTag(dst, additionalTypeTagNetworkPrefix)
+	dst = AppendMapHeader(dst, 1)
+	dst = AppendBytes(dst, ip)
+	return AppendInt(dst, int64(ones))
+}
+
+// AppendEmbeddedJSON appends JSON text as embedded JSON (tag 262).
+func AppendEmbeddedJSON(dst []byte, json []byte) []byte {
+	dst = AppendTag(dst, additionalTypeEmbeddedJSON)
+	return AppendBytes(dst, json)
+}
+
+// AppendHexString appends b as a hex string (tag 263).
+func AppendHexString(dst []byte, b []byte) []byte {
+	dst = AppendTag(dst, additionalTypeTagHexString)
+	return AppendBytes(dst, b)
+}
+
+// AppendBytesExpected appends b tagged with the expected conversion to
+// text (tags 21, 22 and 23) of enc, which must be ByteEncodingBase64URL,
+// ByteEncodingBase64 or ByteEncodingHex.
+func AppendBytesExpected(dst []byte, b []byte, enc ByteEncoding) []byte {
+	for tag, e := range expectedConversions {
+		if e == enc {
+			dst = AppendTag(dst, tag)
+			break
+		}
+	}
+	return AppendBytes(dst, b)
+}
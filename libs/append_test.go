@@ -0,0 +1,81 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAppend(t *testing.T) {
+	_, pfx, _ := net.ParseCIDR("192.168.0.0/16")
+	neg65, _ := new(big.Int).SetString("-18446744073709551617", 10)
+	bf, _ := AppendBigFloat(nil, big.NewFloat(1.5))
+	var appendTestCases = []struct {
+		got  []byte
+		want string
+	}{
+		{AppendUint(nil, 23), "\x17"},
+		{AppendUint(nil, 24), "\x18\x18"},
+		{AppendUint(nil, math.MaxUint64), "\x1b\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{AppendInt(nil, -1), "\x20"},
+		{AppendInt(nil, -500), "\x39\x01\xf3"},
+		{AppendInt(nil, math.MinInt64), "\x3b\x7f\xff\xff\xff\xff\xff\xff\xff"},
+		{AppendBigInt(nil, new(big.Int).Lsh(big.NewInt(1), 64)), "\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{AppendBigInt(nil, neg65), "\xc3\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{AppendBigInt(nil, big.NewInt(-2)), "\x21"},
+		{AppendFloat32(nil, 1.5), "\xfa\x3f\xc0\x00\x00"},
+		{AppendFloat64(nil, 1.1), "\xfb\x3f\xf1\x99\x99\x99\x99\x99\x9a"},
+		{AppendFloat64(nil, math.Inf(-1)), float64NegInfinity},
+		{AppendBool(nil, true), "\xf5"},
+		{AppendNull(nil), "\xf6"},
+		{AppendUndefined(nil), "\xf7"},
+		{AppendString(nil, "IETF"), "\x64IETF"},
+		{AppendBytes(nil, []byte{1, 2}), "\x42\x01\x02"},
+		{AppendArrayHeader(nil, 3), "\x83"},
+		{AppendMapHeader(nil, 30), "\xb8\x1e"},
+		{AppendBreak(AppendMapStart(AppendArrayStart(nil))), "\x9f\xbf\xff"},
+		{AppendTag(nil, 262), "\xd9\x01\x06"},
+		{AppendTime(nil, time.Unix(1363896240, 0)), "\xc1\x1a\x51\x4b\x67\xb0"},
+		{AppendTime(nil, time.Unix(1363896240, 5e8)), "\xc1\xfb\x41\xd4\x52\xd9\xec\x20\x00\x00"},
+		{AppendDecimal(nil, Decimal{Mantissa: big.NewInt(27315), Exponent: -2}), "\xc4\x82\x21\x19\x6a\xb3"},
+		{bf, "\xc5\x82\x20\x03"},
+		{AppendIP(nil, net.ParseIP("10.0.0.1")), "\xd9\x01\x04\x44\x0a\x00\x00\x01"},
+		{AppendMAC(nil, net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0xab}), "\xd9\x01\x04\x46\x12\x34\x56\x78\x90\xab"},
+		{AppendIPNet(nil, *pfx), "\xd9\x01\x05\xa1\x44\xc0\xa8\x00\x00\x10"},
+		{AppendEmbeddedJSON(nil, []byte("[1]")), "\xd9\x01\x06\x43[1]"},
+		{AppendHexString(nil, []byte{0xbe}), "\xd9\x01\x07\x41\xbe"},
+		{AppendBytesExpected(nil, []byte{0xbe}, ByteEncodingHex), "\xd7\x41\xbe"},
+	}
+	for i, tc := range appendTestCases {
+		if string(tc.got) != tc.want {
+			t.Errorf("Append case %d=0x%s, want: 0x%s", i, hex.EncodeToString(tc.got), hex.EncodeToString([]byte(tc.want)))
+		}
+	}
+}
+
+func TestAppendRoundTrip(t *testing.T) {
+	var roundTripTestCases = []struct {
+		bin  []byte
+		json string
+	}{
+		{AppendInt(nil, math.MinInt64), "-9223372036854775808"},
+		{AppendBigInt(nil, new(big.Int).Lsh(big.NewInt(1), 70)), "1180591620717411303424"},
+		{AppendDecimal(nil, Decimal{Mantissa: big.NewInt(-27315), Exponent: -2}), "-273.15"},
+		{AppendIPNet(nil, net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}), "\"2001:db8::/32\""},
+		{AppendEmbeddedJSON(nil, []byte(`{"a": [1, 2]}`)), `{"a":[1,2]}`},
+	}
+	for _, tc := range roundTripTestCases {
+		var buf bytes.Buffer
+		if err := cbor2JsonOneObject(getReader(string(tc.bin)), &buf); err != nil {
+			t.Errorf("cbor2JsonOneObject(0x%s) failed: %v", hex.EncodeToString(tc.bin), err)
+			continue
+		}
+		if buf.String() != tc.json {
+			t.Errorf("cbor2JsonOneObject(0x%s)=%s, want: %s", hex.EncodeToString(tc.bin), buf.String(), tc.json)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package csd
+
+// This file contains helpers to decode bignums (tags 2 and 3), decimal
+// fractions (tag 4) and bigfloats (tag 5).
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Bigfloats whose binary exponent is larger than this (in magnitude) are
+// not converted to decimal at all - it would be too many digits - but
+// rendered as the tag and its content.
+const maxExactBigfloatExponent = 4096
+
+// BigNumberFormat selects how bignums, decimal fractions and bigfloats are
+// rendered in JSON.
+type BigNumberFormat int
+
+const (
+	// BigNumbersDefault uses DecodeBigNumbersAsString.
+	BigNumbersDefault BigNumberFormat = iota
+	// BigNumbersAsNumber renders them as exact JSON numbers.
+	BigNumbersAsNumber
+	// BigNumbersAsString renders them as JSON strings.
+	BigNumbersAsString
+)
+
+// Decimal is the value of a decimal fraction (tag 4):
+// Mantissa * 10^Exponent.
+type Decimal struct {
+	Mantissa *big.Int
+	Exponent int64
+}
+
+// String returns the exact decimal representation of d. A nil Mantissa
+// is 0.
+func (d Decimal) String() string {
+	mant := d.Mantissa
+	if mant == nil {
+		mant = new(big.Int)
+	}
+	return string(appendDecimal([]byte{}, mant, d.Exponent))
+}
+
+// bignumFromContent returns the value of a positive (tag 2) or
+// negative (tag 3) bignum given its decoded content.
+func bignumFromContent(tag uint64, content interface{}) (*big.Int, error) {
+	octets, ok := content.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("Bignum content is NOT a byte string as expected")
+	}
+	n := new(big.Int).SetBytes(octets)
+	if tag == additionalTypeTagNegativeBignum {
+		n.Add(n, big.NewInt(1))
+		n.Neg(n)
+	}
+	return n, nil
+}
+
+// bigFractionFromContent returns the mantissa and exponent of a decimal
+// fraction or bigfloat given its decoded content - an array of the
+// exponent and an integer or bignum mantissa.
+func bigFractionFromContent(content interface{}) (*big.Int, int64, error) {
+	arr, ok := content.([]interface{})
+	if !ok || len(arr) != 2 {
+		return nil, 0, fmt.Errorf("Decimal fraction/bigfloat is NOT an ARRAY of 2 elements as expected")
+	}
+	exp, ok := arr[0].(int64)
+	if !ok {
+		return nil, 0, fmt.Errorf("Unsupported exponent type: %T in decimal fraction/bigfloat", arr[0])
+	}
+	switch m := arr[1].(type) {
+	case int64:
+		return big.NewInt(m), exp, nil
+	case uint64:
+		return new(big.Int).SetUint64(m), exp, nil
+	case *big.Int:
+		return m, exp, nil
+	}
+	return nil, 0, fmt.Errorf("Unsupported mantissa type: %T in decimal fraction/bigfloat", arr[1])
+}
+
+// renderBigNumber returns the JSON renderer for a bignum, decimal
+// fraction or bigfloat tag.
+func renderBigNumber(tag uint64) TagJSONRenderer {
+	return func(content interface{}, opts *DecoderOptions) ([]byte, error) {
+		ba := []byte{}
+		if opts.BigNumbers == BigNumbersAsString {
+			ba = append(ba, '"')
+		}
+		switch tag {
+		case additionalTypeTagPositiveBignum, additionalTypeTagNegativeBignum:
+			n, err := bignumFromContent(tag, content)
+			if err != nil {
+				return nil, err
+			}
+			ba = n.Append(ba, 10)
+		case additionalTypeTagDecimalFraction:
+			mant, exp, err := bigFractionFromContent(content)
+			if err != nil {
+				return nil, err
+			}
+			ba = appendDecimal(ba, mant, exp)
+		case additionalTypeTagBigfloat:
+			mant, exp, err := bigFractionFromContent(content)
+			if err != nil {
+				return nil, err
+			}
+			m, e, ok := bigfloatToDecimal(mant, exp)
+			if !ok {
+				// Even an approximate decimal takes time growing with the
+				// exponent, render it the way unknown tags are.
+				return appendRawBigfloat(nil, mant, exp), nil
+			}
+			ba = appendDecimal(ba, m, e)
+		}
+		if opts.BigNumbers == BigNumbersAsString {
+			ba = append(ba, '"')
+		}
+		return ba, nil
+	}
+}
+
+func unmarshalBignum(tag uint64) TagUnmarshaler {
+	return func(content interface{}, opts *DecoderOptions) (interface{}, error) {
+		return bignumFromContent(tag, content)
+	}
+}
+
+func unmarshalDecimalFraction(content interface{}, opts *DecoderOptions) (interface{}, error) {
+	mant, exp, err := bigFractionFromContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return Decimal{Mantissa: mant, Exponent: exp}, nil
+}
+
+func unmarshalBigfloat(content interface{}, opts *DecoderOptions) (interface{}, error) {
+	mant, exp, err := bigFractionFromContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return bigfloatToFloat(mant, exp)
+}
+
+// bigfloatToDecimal converts the bigfloat mant * 2^exp to the decimal
+// mant' * 10^exp' holding the same value. ok is false if the exponent is
+// too large to expand exactly.
+func bigfloatToDecimal(mant *big.Int, exp int64) (*big.Int, int64, bool) {
+	switch {
+	case exp > maxExactBigfloatExponent || exp < -maxExactBigfloatExponent:
+		return nil, 0, false
+	case exp >= 0:
+		return new(big.Int).Lsh(mant, uint(exp)), 0, true
+	}
+	// mant * 2^-k == mant * 5^k * 10^-k
+	p := new(big.Int).Exp(big.NewInt(5), big.NewInt(-exp), nil)
+	return p.Mul(p, mant), exp, true
+}
+
+// appendRawBigfloat appends the bigfloat mant * 2^exp as the tag and its
+// content, {"@tag":5,"@value":[exp,mant]}.
+func appendRawBigfloat(dst []byte, mant *big.Int, exp int64) []byte {
+	dst = append(dst, `{"@tag":`...)
+	dst = strconv.AppendUint(dst, additionalTypeTagBigfloat, 10)
+	dst = append(dst, `,"@value":[`...)
+	dst = strconv.AppendInt(dst, exp, 10)
+	dst = append(dst, ',')
+	dst = mant.Append(dst, 10)
+	return append(dst, "]}"...)
+}
+
+// bigfloatToFloat returns the bigfloat mant * 2^exp as an exact *big.Float.
+func bigfloatToFloat(mant *big.Int, exp int64) (*big.Float, error) {
+	prec := uint(mant.BitLen())
+	if prec == 0 {
+		prec = 1
+	}
+	f := new(big.Float).SetPrec(prec).SetInt(mant)
+	if exp < big.MinExp || exp > big.MaxExp {
+		return nil, fmt.Errorf("Bigfloat exponent out of range: %d", exp)
+	}
+	return f.SetMantExp(f, int(exp)), nil
+}
+
+// appendDecimal appends mant * 10^exp as a JSON number, using exponent
+// notation when a plain decimal would need many padding zeros.
+func appendDecimal(dst []byte, mant *big.Int, exp int64) []byte {
+	if mant.Sign() < 0 {
+		dst = append(dst, '-')
+	}
+	digits := new(big.Int).Abs(mant).String()
+	switch {
+	case exp == 0:
+		return append(dst, digits...)
+	case exp < 0 && exp > -int64(len(digits)):
+		point := len(digits) + int(exp)
+		dst = append(dst, digits[:point]...)
+		dst = append(dst, '.')
+		return append(dst, digits[point:]...)
+	case exp < 0 && exp >= -int64(len(digits))-6:
+		dst = append(dst, '0', '.')
+		for i := int64(len(digits)); i < -exp; i++ {
+			dst = append(dst, '0')
+		}
+		return append(dst, digits...)
+	}
+	dst = append(dst, digits...)
+	dst = append(dst, 'e')
+	return strconv.AppendInt(dst, exp, 10)
+}
@@ -0,0 +1,84 @@
+package csd
+
+// This file contains the rendering of byte strings as JSON strings.
+
+import (
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ByteEncoding selects how byte strings are rendered as JSON strings.
+type ByteEncoding int
+
+const (
+	// ByteEncodingDefault is ByteEncodingBase64.
+	ByteEncodingDefault ByteEncoding = iota
+	// ByteEncodingBase64 is standard base64 with padding, the same as
+	// encoding/json uses for []byte.
+	ByteEncodingBase64
+	// ByteEncodingBase64URL is base64url without padding.
+	ByteEncodingBase64URL
+	// ByteEncodingHex is lower case base16.
+	ByteEncodingHex
+	// ByteEncodingLatin1 maps every byte to the character with the same
+	// code point (ISO 8859-1), escaped as needed - handy for byte strings
+	// holding mostly ASCII text.
+	ByteEncodingLatin1
+)
+
+var byteEncodingNames = []string{"default", "base64", "base64url", "hex", "latin1"}
+
+func (e ByteEncoding) String() string {
+	if e < 0 || int(e) >= len(byteEncodingNames) {
+		return fmt.Sprintf("ByteEncoding(%d)", int(e))
+	}
+	return byteEncodingNames[e]
+}
+
+// ParseByteEncoding returns the ByteEncoding called name - one of base64,
+// base64url, hex or latin1.
+func ParseByteEncoding(name string) (ByteEncoding, error) {
+	for i, n := range byteEncodingNames {
+		if i > 0 && n == name {
+			return ByteEncoding(i), nil
+		}
+	}
+	return ByteEncodingDefault, fmt.Errorf("Unknown byte string encoding: %q (expected base64, base64url, hex or latin1)", name)
+}
+
+// expectedConversions maps the expected conversion tags to the encoding
+// they ask for.
+var expectedConversions = map[uint64]ByteEncoding{
+	additionalTypeTagExpectedBase64URL: ByteEncodingBase64URL,
+	additionalTypeTagExpectedBase64:    ByteEncodingBase64,
+	additionalTypeTagExpectedBase16:    ByteEncodingHex,
+}
+
+// appendByteString appends b as a JSON string in the encoding enc.
+func appendByteString(dst []byte, b []byte, enc ByteEncoding) []byte {
+	dst = append(dst, '"')
+	switch enc {
+	case ByteEncodingBase64URL:
+		n := len(dst)
+		dst = append(dst, make([]byte, base64.RawURLEncoding.EncodedLen(len(b)))...)
+		base64.RawURLEncoding.Encode(dst[n:], b)
+	case ByteEncodingHex:
+		for _, v := range b {
+			dst = append(dst, hexTable[v>>4], hexTable[v&0x0f])
+		}
+	case ByteEncodingLatin1:
+		s := make([]byte, 0, len(b))
+		var r [utf8.UTFMax]byte
+		for _, v := range b {
+			n := utf8.EncodeRune(r[:], rune(v))
+			s = append(s, r[:n]...)
+		}
+		dst = decodeStringComplex(dst, string(s), 0)
+	default:
+		n := len(dst)
+		dst = append(dst, make([]byte, base64.StdEncoding.EncodedLen(len(b)))...)
+		base64.StdEncoding.Encode(dst[n:], b)
+	}
+	return append(dst, '"')
+}
@@ -0,0 +1,82 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeByteString(t *testing.T) {
+	var byteStringTestCases = []struct {
+		binary string
+		enc    ByteEncoding
+		json   string
+	}{
+		{"\x40", ByteEncodingBase64, "\"\""},
+		{"\x43\x01\x02\xff", ByteEncodingBase64, "\"AQL/\""},
+		{"\x44\"\\\x00\xfe", ByteEncodingBase64, "\"IlwA/g==\""},
+		{"\x44\"\\\x00\xfe", ByteEncodingBase64URL, "\"IlwA_g\""},
+		{"\x44\"\\\x00\xfe", ByteEncodingHex, "\"225c00fe\""},
+		{"\x44\"\\\x00\xfe", ByteEncodingLatin1, "\"\\\"\\\\\\u0000\u00fe\""},
+		{"\x5f\x41\x01\x42\x02\x03\xff", ByteEncodingHex, "\"010203\""},
+		// Expected conversion tags override the selected encoding.
+		{"\xd5\x44\"\\\x00\xfe", ByteEncodingHex, "\"IlwA_g\""},
+		{"\xd6\x44\"\\\x00\xfe", ByteEncodingHex, "\"IlwA/g==\""},
+		{"\xd7\x44\"\\\x00\xfe", ByteEncodingBase64, "\"225c00fe\""},
+		// ... for all byte strings in their content, until the next one.
+		{"\xd7\x83\x41\x01\xd5\x41\xfb\x41\x02", ByteEncodingLatin1, "[\"01\",\"-w\",\"02\"]"},
+		{"\x82\xd7\x41\x01\x41\x01", ByteEncodingLatin1, "[\"01\",\"\\u0001\"]"},
+	}
+	for _, tc := range byteStringTestCases {
+		buf := bytes.NewBuffer([]byte{})
+		rdr := newCborReader(strings.NewReader(tc.binary), resolveOptions([]DecoderOptions{{ByteEncoding: tc.enc}}))
+		if err := cbor2JsonOneObject(rdr, buf); err != nil {
+			t.Fatalf("cbor2JsonOneObject(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if buf.String() != tc.json {
+			t.Errorf("cbor2JsonOneObject(0x%s) in %s=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), tc.enc, buf.String(), tc.json)
+		}
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("cbor2JsonOneObject(0x%s) in %s=%s is not valid JSON", hex.EncodeToString([]byte(tc.binary)), tc.enc, buf.String())
+		}
+	}
+}
+
+func TestByteStringMatchesEncodingJSON(t *testing.T) {
+	b := []byte("\x00\x01\"\\<>&\x7f\x80\xff binary")
+	bin := appendCborTypePrefix([]byte{}, majorTypeByteString, uint64(len(b)))
+	bin = append(bin, b...)
+	got, err := decodeString(getReader(string(bin)), false)
+	if err != nil {
+		t.Fatalf("decodeString(0x%s) failed: %v", hex.EncodeToString(bin), err)
+	}
+	want, _ := json.Marshal(b)
+	if string(got) != string(want) {
+		t.Errorf("decodeString(0x%s)=%s, want: %s", hex.EncodeToString(bin), got, want)
+	}
+}
+
+func TestUnmarshalExpectedConversion(t *testing.T) {
+	bin := "\xd6\x42\x01\x02"
+	v, err := unmarshalTagData(getReader(bin))
+	if err != nil {
+		t.Fatalf("unmarshalTagData(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	if b, ok := v.([]byte); !ok || string(b) != "\x01\x02" {
+		t.Errorf("unmarshalTagData(0x%s)=%v, want: [1 2]", hex.EncodeToString([]byte(bin)), v)
+	}
+}
+
+func TestParseByteEncoding(t *testing.T) {
+	for _, name := range []string{"base64", "base64url", "hex", "latin1"} {
+		enc, err := ParseByteEncoding(name)
+		if err != nil || enc.String() != name {
+			t.Errorf("ParseByteEncoding(%s)=%s (err: %v)", name, enc, err)
+		}
+	}
+	if _, err := ParseByteEncoding("default"); err == nil {
+		t.Errorf("ParseByteEncoding(default) succeeded, want error")
+	}
+}
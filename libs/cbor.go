@@ -12,6 +12,7 @@ const (
 	additionalTypeBoolFalse byte = 20
 	additionalTypeBoolTrue  byte = 21
 	additionalTypeNull      byte = 22
+	additionalTypeUndefined byte = 23
 
 	// Integer (+ve and -ve) Sub-types.
 	additionalTypeIntUint8  byte = 24
@@ -26,13 +27,22 @@ const (
 	additionalTypeBreak   byte = 31
 
 	// Tag Sub-types.
-	additionalTypeTimestamp byte = 01
+	additionalTypeTimestamp          uint64 = 01
+	additionalTypeTagPositiveBignum  uint64 = 02
+	additionalTypeTagNegativeBignum  uint64 = 03
+	additionalTypeTagDecimalFraction uint64 = 04
+	additionalTypeTagBigfloat        uint64 = 05
+
+	// Expected conversion of byte strings to text.
+	additionalTypeTagExpectedBase64URL uint64 = 21
+	additionalTypeTagExpectedBase64    uint64 = 22
+	additionalTypeTagExpectedBase16    uint64 = 23
 
 	// Extended Tags - from https://www.iana.org/assignments/cbor-tags/cbor-tags.xhtml
-	additionalTypeTagNetworkAddr   uint16 = 260
-	additionalTypeTagNetworkPrefix uint16 = 261
-	additionalTypeEmbeddedJSON     uint16 = 262
-	additionalTypeTagHexString     uint16 = 263
+	additionalTypeTagNetworkAddr   uint64 = 260
+	additionalTypeTagNetworkPrefix uint64 = 261
+	additionalTypeEmbeddedJSON     uint64 = 262
+	additionalTypeTagHexString     uint64 = 263
 
 	// Unspecified number of elements.
 	additionalTypeInfiniteCount byte = 31
@@ -63,17 +73,24 @@ const (
 )
 
 // IntegerTimeFieldFormat indicates the format of timestamp decoded
-// from an integer (time in seconds).
+// from an integer (time in seconds). This is the default of
+// DecoderOptions.IntegerTimeFormat.
 var IntegerTimeFieldFormat = time.RFC3339
 
 // NanoTimeFieldFormat indicates the format of timestamp decoded
-// from a float value (time in seconds and nano seconds).
+// from a float value (time in seconds and nano seconds). This is the
+// default of DecoderOptions.NanoTimeFormat.
 var NanoTimeFieldFormat = time.RFC3339Nano
 
+// appendCborTypePrefix appends the head of a data item of the given major
+// type and argument, in the shortest form.
 func appendCborTypePrefix(dst []byte, major byte, number uint64) []byte {
 	byteCount := 8
 	var minor byte
 	switch {
+	case number <= additionalMax:
+		return append(dst, major|byte(number))
+
 	case number < 256:
 		byteCount = 1
 		minor = additionalTypeIntUint8
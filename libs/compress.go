@@ -0,0 +1,306 @@
+package csd
+
+// This file contains the detection and decompression of compressed input.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/lzw"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Compression selects the compression of an input stream.
+type Compression int
+
+const (
+	// CompressionAuto detects the compression from the start of the
+	// stream - see DetectCompression.
+	CompressionAuto Compression = iota
+	// CompressionNone is uncompressed input.
+	CompressionNone
+	// CompressionZlib is RFC 1950 zlib.
+	CompressionZlib
+	// CompressionGzip is RFC 1952 gzip.
+	CompressionGzip
+	// CompressionFlate is RFC 1951 raw deflate, without header.
+	CompressionFlate
+	// CompressionBzip2 is bzip2.
+	CompressionBzip2
+	// CompressionLZW is LZW as written by compress/lzw, least significant
+	// bit first with 8 bit literals. It has no header, and is never
+	// detected.
+	CompressionLZW
+)
+
+var compressionNames = []string{"auto", "none", "zlib", "gzip", "flate", "bzip2", "lzw"}
+
+func (c Compression) String() string {
+	if c < 0 || int(c) >= len(compressionNames) {
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+	return compressionNames[c]
+}
+
+// ParseCompression returns the Compression called name - one of auto,
+// none, zlib, gzip, flate, bzip2 or lzw.
+func ParseCompression(name string) (Compression, error) {
+	for i, n := range compressionNames {
+		if n == name {
+			return Compression(i), nil
+		}
+	}
+	return CompressionNone, fmt.Errorf("Unknown compression: %q (expected auto, none, zlib, gzip, flate, bzip2 or lzw)", name)
+}
+
+// flateSniffMin is the number of bytes that have to decompress as raw
+// deflate, unless the deflate stream ends before, for DetectCompression to
+// take them for deflate.
+const flateSniffMin = 32
+
+// DetectCompression returns the compression of the stream read by src,
+// without consuming any of it: gzip, bzip2 and zlib by their headers, and
+// raw deflate (which has none) if its start decompresses cleanly - which
+// may need to wait for more than the bytes buffered. Anything else is
+// CompressionNone - CBOR that happens to start like a zlib header or a
+// deflate block needs an explicit compression.
+//
+// Unix compress (.Z) files are rejected, compress/lzw can not read them.
+func DetectCompression(src *bufio.Reader) (Compression, error) {
+	b, err := src.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return CompressionNone, nil
+		}
+		return CompressionNone, err
+	}
+	if b[0] == 0x1f || b[0] == 'B' || b[0]&0x0f == 8 {
+		b, err = src.Peek(2)
+		if err != nil && err != io.EOF {
+			return CompressionNone, err
+		}
+	}
+	switch {
+	case len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b:
+		return CompressionGzip, nil
+	case len(b) >= 2 && b[0] == 0x1f && b[1] == 0x9d:
+		return CompressionNone, fmt.Errorf("csd: unix compress (.Z) input is not supported")
+	case len(b) >= 2 && b[0] == 'B' && b[1] == 'Z':
+		if b, _ := src.Peek(4); len(b) == 4 && b[2] == 'h' && b[3] >= '1' && b[3] <= '9' {
+			return CompressionBzip2, nil
+		}
+	case len(b) >= 2 && b[0]&0x0f == 8 && b[0]>>4 <= 7 && (uint(b[0])<<8|uint(b[1]))%31 == 0:
+		return CompressionZlib, nil
+	}
+	if isFlate(src) {
+		return CompressionFlate, nil
+	}
+	return CompressionNone, nil
+}
+
+// isFlate reports whether the bytes buffered by src decompress as raw
+// deflate. If they are a valid but short start of a deflate stream, and
+// not complete CBOR data items either, it waits for more - up to
+// flateSniffMin bytes - to decide.
+func isFlate(src *bufio.Reader) bool {
+	b, _ := src.Peek(src.Buffered())
+	for {
+		if len(b) == 0 || b[0]&0x06 == 0x06 {
+			// Reserved block type.
+			return false
+		}
+		br := bytes.NewReader(b)
+		n, err := io.Copy(ioutil.Discard, flate.NewReader(br))
+		used := len(b) - br.Len()
+		switch {
+		case err == nil:
+			// A complete stream - ending the input, or long enough not
+			// to be chance when followed by the next segment.
+			return n > 0 && (br.Len() == 0 || used >= flateSniffMin)
+		case err != io.ErrUnexpectedEOF:
+			return false
+		case len(b) >= flateSniffMin:
+			return true
+		case b[0]&0xfe == 0 && len(b) >= 5 && b[1]^b[3] == 0xff && b[2]^b[4] == 0xff:
+			// A stored block, its length followed by the complement.
+			return true
+		case isCborItems(b):
+			// Not worth waiting for more, which may take long when
+			// following a file.
+			return false
+		}
+		more, err := src.Peek(len(b) + 1)
+		if err != nil && len(more) == len(b) {
+			// The input ended (or failed) with nothing more to decide.
+			return false
+		}
+		b = more
+	}
+}
+
+// isCborItems reports whether b holds one or more complete CBOR data
+// items.
+func isCborItems(b []byte) bool {
+	src := newCborReader(bytes.NewReader(b), resolveOptions(nil))
+	for {
+		if _, err := readRawItem(src, nil); err != nil {
+			return false
+		}
+		if _, err := src.Peek(1); err == io.EOF {
+			return true
+		}
+	}
+}
+
+// Segment is one of the compressed members of a stream read by
+// NewDecompressor - writers start a new one on every log rotation or
+// restart.
+type Segment struct {
+	// Index counts the segments from 0.
+	Index int
+	// Compression of the segment.
+	Compression Compression
+	// Start is the offset of the segment in the compressed stream.
+	Start int64
+	// Offset is the offset of its first byte in the decompressed stream.
+	Offset int64
+}
+
+func (s Segment) String() string {
+	return fmt.Sprintf("segment %d: %s at offset %d (decompressed offset %d)", s.Index, s.Compression, s.Start, s.Offset)
+}
+
+// NewDecompressor returns a reader of the decompressed stream read from
+// src, compressed with c. CompressionAuto detects the compression with
+// DetectCompression.
+//
+// src may hold several compressed members (segments) one after the other,
+// as written by appending to a file with a new compressor after rotation
+// or restart. They are decompressed as one stream - with CompressionAuto,
+// each with the compression it is detected to have. Only bzip2 can follow
+// bzip2 (compress/bzip2 reads on into the next segment), and uncompressed
+// data runs to the end.
+func NewDecompressor(src io.Reader, c Compression) (io.ReadCloser, error) {
+	return NewDecompressorSegments(src, c, nil)
+}
+
+// NewDecompressorSegments is NewDecompressor, also calling onSegment (if
+// not nil) at the start of every segment.
+func NewDecompressorSegments(src io.Reader, c Compression, onSegment func(Segment)) (io.ReadCloser, error) {
+	cnt := &countingReader{r: src}
+	s := &segmentReader{src: bufio.NewReader(cnt), cnt: cnt, c: c, onSegment: onSegment}
+	// Open the first segment, for errors in its header.
+	if err := s.next(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return s, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type segmentReader struct {
+	// src is read by the decompressors. It is an io.ByteReader, so they
+	// read no further than the end of their segment.
+	src       *bufio.Reader
+	cnt       *countingReader
+	c         Compression
+	onSegment func(Segment)
+	cur       io.ReadCloser
+	index     int
+	// out is the number of decompressed bytes read.
+	out int64
+	err error
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for s.err == nil {
+		if s.cur == nil {
+			if s.err = s.next(); s.err != nil {
+				break
+			}
+		}
+		n, err := s.cur.Read(p)
+		s.out += int64(n)
+		if err == io.EOF {
+			// The end of the segment, not (yet) of the stream.
+			s.cur.Close()
+			s.cur = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, s.err
+}
+
+// next starts the next segment, returning io.EOF at the end of src.
+func (s *segmentReader) next() error {
+	if _, err := s.src.Peek(1); err != nil {
+		return err
+	}
+	c := s.c
+	if c == CompressionAuto {
+		var err error
+		if c, err = DetectCompression(s.src); err != nil {
+			return err
+		}
+	}
+	start := s.cnt.n - int64(s.src.Buffered())
+	r, err := newSegmentDecompressor(s.src, c)
+	if err != nil {
+		return fmt.Errorf("csd: %s segment at offset %d: %v", c, start, err)
+	}
+	s.cur = r
+	if s.onSegment != nil {
+		s.onSegment(Segment{Index: s.index, Compression: c, Start: start, Offset: s.out})
+	}
+	s.index++
+	return nil
+}
+
+func (s *segmentReader) Close() error {
+	if s.cur != nil {
+		return s.cur.Close()
+	}
+	return nil
+}
+
+// newSegmentDecompressor returns a reader of the segment compressed with
+// c at the start of src.
+func newSegmentDecompressor(src *bufio.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case CompressionNone:
+		return ioutil.NopCloser(src), nil
+	case CompressionZlib:
+		return zlib.NewReader(src)
+	case CompressionGzip:
+		z, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+		z.Multistream(false)
+		return z, nil
+	case CompressionFlate:
+		return flate.NewReader(src), nil
+	case CompressionBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(src)), nil
+	case CompressionLZW:
+		return lzw.NewReader(src, lzw.LSB, 8), nil
+	}
+	return nil, fmt.Errorf("unknown compression %s", c)
+}
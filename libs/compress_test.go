@@ -0,0 +1,193 @@
+package csd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/lzw"
+	"compress/zlib"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// compressTestRecord is a typical log record, 3 times over.
+var compressTestRecord = strings.Repeat("\xa3\x65level\x64info\x64time\xc1\x1a\x5a\xbf\x8d\x6f\x62ip\xd9\x01\x04\x44\xc0\xa8\x0a\x66", 3)
+
+// bzip2TestRecord is compressTestRecord compressed with bzip2 -9 (the
+// standard library has no bzip2 writer).
+const bzip2TestRecord = "425a6839314159265359a97897e200002b67de24100010040000101727c500000208400000e0000020200050a0" +
+	"006819320cffd550c800686992ab53550609395af5f2f76f1b26924eec7b63e4d72282882aa288aafc5dc914e1" +
+	"4242a5e25f88"
+
+func compressWith(t *testing.T, c Compression, b []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch c {
+	case CompressionZlib:
+		w = zlib.NewWriter(&buf)
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionFlate:
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case CompressionLZW:
+		w = lzw.NewWriter(&buf, lzw.LSB, 8)
+	case CompressionBzip2:
+		b, err := hex.DecodeString(bzip2TestRecord)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	default:
+		return b
+	}
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDetectCompression(t *testing.T) {
+	for _, tc := range []struct {
+		c    Compression
+		want Compression
+	}{
+		{CompressionNone, CompressionNone},
+		{CompressionZlib, CompressionZlib},
+		{CompressionGzip, CompressionGzip},
+		{CompressionFlate, CompressionFlate},
+		{CompressionBzip2, CompressionBzip2},
+		{CompressionLZW, CompressionNone},
+	} {
+		in := compressWith(t, tc.c, []byte(compressTestRecord))
+		got, err := DetectCompression(bufio.NewReader(bytes.NewReader(in)))
+		if err != nil || got != tc.want {
+			t.Errorf("DetectCompression(%s)=%s, %v, want: %s", tc.c, got, err, tc.want)
+		}
+	}
+	// Plain CBOR is never taken for compressed.
+	inputs := []string{"", compressTestRecord}
+	for _, tc := range diagTestCases {
+		inputs = append(inputs, tc.binary)
+	}
+	for _, tc := range hexdumpTestCases {
+		inputs = append(inputs, tc.binary)
+	}
+	for _, in := range inputs {
+		if got, err := DetectCompression(bufio.NewReader(strings.NewReader(in))); err != nil || got != CompressionNone {
+			t.Errorf("DetectCompression(0x%s)=%s, %v, want: none", hex.EncodeToString([]byte(in)), got, err)
+		}
+	}
+	if _, err := DetectCompression(bufio.NewReader(strings.NewReader("\x1f\x9d\x90"))); err == nil {
+		t.Errorf("DetectCompression(.Z) succeeded, want an error")
+	}
+}
+
+// TestDetectCompressionWaits checks that raw deflate is detected from a
+// stream that delivers fewer than flateSniffMin bytes at first.
+func TestDetectCompressionWaits(t *testing.T) {
+	in := compressWith(t, CompressionFlate, []byte(compressTestRecord))
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(in[:3])
+		pw.Write(in[3:])
+		pw.Close()
+	}()
+	if got, err := DetectCompression(bufio.NewReader(pr)); err != nil || got != CompressionFlate {
+		t.Errorf("DetectCompression(flate, 3 bytes first)=%s, %v, want: flate", got, err)
+	}
+}
+
+// TestDetectCompressionShortCbor checks that a short CBOR record, a valid
+// start of a deflate stream, is not held up waiting for flateSniffMin bytes.
+func TestDetectCompressionShortCbor(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("\xa1\x61a\x01"))
+	if got, err := DetectCompression(bufio.NewReader(pr)); err != nil || got != CompressionNone {
+		t.Errorf("DetectCompression(a1616101)=%s, %v, want: none", got, err)
+	}
+}
+
+func TestNewDecompressor(t *testing.T) {
+	for _, c := range []Compression{CompressionNone, CompressionZlib, CompressionGzip, CompressionFlate, CompressionBzip2, CompressionLZW} {
+		in := compressWith(t, c, []byte(compressTestRecord))
+		for _, mode := range []Compression{c, CompressionAuto} {
+			if c == CompressionLZW && mode == CompressionAuto {
+				continue
+			}
+			r, err := NewDecompressor(bytes.NewReader(in), mode)
+			if err != nil {
+				t.Errorf("NewDecompressor(%s, %s) failed: %v", c, mode, err)
+				continue
+			}
+			got, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil || string(got) != compressTestRecord {
+				t.Errorf("NewDecompressor(%s, %s)=0x%s, %v, want: 0x%s", c, mode, hex.EncodeToString(got), err, hex.EncodeToString([]byte(compressTestRecord)))
+			}
+		}
+	}
+}
+
+func TestNewDecompressorSegments(t *testing.T) {
+	rec := []byte(compressTestRecord)
+	var in []byte
+	var want []Segment
+	for i, c := range []Compression{CompressionZlib, CompressionZlib, CompressionGzip, CompressionFlate, CompressionNone} {
+		want = append(want, Segment{Index: i, Compression: c, Start: int64(len(in)), Offset: int64(i * len(rec))})
+		in = append(in, compressWith(t, c, rec)...)
+	}
+	var got []Segment
+	r, err := NewDecompressorSegments(bytes.NewReader(in), CompressionAuto, func(s Segment) {
+		got = append(got, s)
+	})
+	if err != nil {
+		t.Fatalf("NewDecompressorSegments failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Cbor2JsonManyObjects(r, &out); err != nil {
+		t.Fatalf("Cbor2JsonManyObjects failed: %v", err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 3*len(want) {
+		t.Errorf("decoded %d records, want: %d", n, 3*len(want))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("segments=%v, want: %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("segment %d=%v, want: %v", i, got[i], want[i])
+		}
+	}
+
+	// With an explicit compression, every segment has to have it.
+	in = append(compressWith(t, CompressionZlib, rec), compressWith(t, CompressionZlib, rec)...)
+	r, _ = NewDecompressor(bytes.NewReader(in), CompressionZlib)
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != compressTestRecord+compressTestRecord {
+		t.Errorf("NewDecompressor(zlib, zlib)=0x%s, %v", hex.EncodeToString(b), err)
+	}
+	in = append(compressWith(t, CompressionGzip, rec), compressWith(t, CompressionBzip2, rec)...)
+	r, _ = NewDecompressor(bytes.NewReader(in), CompressionAuto)
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != compressTestRecord+compressTestRecord {
+		t.Errorf("NewDecompressor(gzip, bzip2)=0x%s, %v", hex.EncodeToString(b), err)
+	}
+	in = append(compressWith(t, CompressionZlib, rec), rec...)
+	r, _ = NewDecompressor(bytes.NewReader(in), CompressionZlib)
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("NewDecompressor(zlib, none) succeeded, want an error")
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for i, name := range compressionNames {
+		if c, err := ParseCompression(name); err != nil || c != Compression(i) || c.String() != name {
+			t.Errorf("ParseCompression(%s)=%s, %v", name, c, err)
+		}
+	}
+	if _, err := ParseCompression("zip"); err == nil {
+		t.Errorf("ParseCompression(zip) succeeded, want an error")
+	}
+}
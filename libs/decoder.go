@@ -5,11 +5,8 @@ package csd
 import (
 	"bufio"
 	"bytes"
-	"fmt"
 	"io"
 	"math"
-	"net"
-	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -18,59 +15,187 @@ import (
 
 // DecodeTimeZone - set this variable if a specific TZ should be
 // used when decoding timestamps. If NOT set, timestamps will be
-// decoded to UTC Timestamps.
+// decoded to UTC Timestamps. This is the default of
+// DecoderOptions.TimeZone.
 var DecodeTimeZone *time.Location
 
+// DecodeBigNumbersAsString - set this variable to render bignums,
+// decimal fractions and bigfloats as JSON strings instead of (exact)
+// JSON numbers, for consumers that parse numbers into float64. This is
+// the default of DecoderOptions.BigNumbers.
+var DecodeBigNumbersAsString = false
+
 const hexTable = "0123456789abcdef"
 
+const maxInt = uint64(^uint(0) >> 1)
+
+const isFloat16 = 2
 const isFloat32 = 4
 const isFloat64 = 8
 
 type Decoder struct {
-	src *bufio.Reader
+	src *cborReader
+	// stack holds the arrays, maps and indefinite length strings the
+	// Token API is in.
+	stack []tokenFrame
+	// tagged is set when the Token API returned a Tag whose content has
+	// not been started yet; taggedKey if the tagged value is a map key.
+	tagged, taggedKey bool
+	// tags is the number of tags (each counting towards MaxDepth) of the
+	// value being read.
+	tags int
 }
 
-func NewDecoder(src io.Reader) *Decoder {
-	return &Decoder{bufio.NewReader(src)}
+// NewDecoder returns a Decoder reading from src. If opts are given, the
+// first of them configures the decoder.
+func NewDecoder(src io.Reader, opts ...DecoderOptions) *Decoder {
+	return &Decoder{src: newCborReader(src, resolveOptions(opts))}
 }
 
+// Next decodes the next CBOR map from the stream. Integers are returned
+// as int64 when they fit, as uint64 for larger positive values and as
+// *big.Int for negative values below math.MinInt64. Bignums are returned
+// as *big.Int, decimal fractions as Decimal and bigfloats as *big.Float.
+//
+// At the end of the stream Next returns io.EOF. Malformed or truncated
+// records are reported as a *DecodeError.
 func (d *Decoder) Next() (map[string]interface{}, error) {
-	return unmarshalMap(d.src), nil
+	if _, err := d.src.Peek(1); err != nil {
+		return nil, err
+	}
+	d.src.startRecord()
+	m, err := unmarshalMap(d.src)
+	d.src.record++
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-func recoverPanic() {
-	recover()
+// NextAny is like Next, but returns the record and all maps in it as
+// map[interface{}]interface{} keyed by the decoded keys (int64, string,
+// bool, ...). Byte string keys are returned as strings; keys that cannot
+// be Go map keys (arrays, maps) are reported as a *DecodeError. The
+// content of tags is still decoded as by Next.
+func (d *Decoder) NextAny() (map[interface{}]interface{}, error) {
+	if _, err := d.src.Peek(1); err != nil {
+		return nil, err
+	}
+	d.src.startRecord()
+	d.src.anyKeys = true
+	m, err := unmarshalAnyMap(d.src)
+	d.src.anyKeys = false
+	d.src.record++
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
+// SafeNext is the same as Next.
+//
+// Deprecated: Next no longer panics on malformed input.
 func (d *Decoder) SafeNext() (map[string]interface{}, error) {
-	defer recoverPanic()
-	return unmarshalMap(d.src), nil
+	return d.Next()
+}
+
+// cborReader is the buffered source of all decode functions. It keeps
+// track of the stream offset and the current record for error reporting.
+type cborReader struct {
+	*bufio.Reader
+	off         int64
+	record      int
+	recordStart int64
+	depth       int
+	opts        *DecoderOptions
+	limits      Limits
+	// byteEncoding is the encoding of byte strings, which expected
+	// conversion tags change for their content.
+	byteEncoding ByteEncoding
+	// anyKeys is set to unmarshal maps as map[interface{}]interface{}.
+	anyKeys bool
+}
+
+func newCborReader(src io.Reader, opts *DecoderOptions) *cborReader {
+	return &cborReader{Reader: bufio.NewReader(src), opts: opts, limits: *opts.Limits, byteEncoding: opts.ByteEncoding}
+}
+
+func (r *cborReader) ReadByte() (byte, error) {
+	b, err := r.Reader.ReadByte()
+	if err == nil {
+		r.off++
+	}
+	return b, err
+}
+
+func (r *cborReader) UnreadByte() error {
+	err := r.Reader.UnreadByte()
+	if err == nil {
+		r.off--
+	}
+	return err
+}
+
+// readHead reads the initial byte of the next data item.
+func readHead(src *cborReader) (head, error) {
+	off := src.off
+	b, err := src.ReadByte()
+	if err != nil {
+		return head{}, src.eofError(err)
+	}
+	h := head{off, b}
+	if err := src.checkRecordSize(h, 0); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// peekHead returns the initial byte of the next data item without
+// consuming it.
+func peekHead(src *cborReader) (head, error) {
+	pb, err := src.Peek(1)
+	if err != nil {
+		return head{}, src.eofError(err)
+	}
+	return head{src.off, pb[0]}, nil
 }
 
-func readNBytes(src *bufio.Reader, n int) []byte {
+// readNBytes reads n bytes of the payload of data item h.
+func readNBytes(src *cborReader, h head, n uint64) ([]byte, error) {
+	if err := src.checkRecordSize(h, n); err != nil {
+		return nil, err
+	}
+	if n > maxInt {
+		return nil, src.errorf(h, "Length %d too large", n)
+	}
 	ret := make([]byte, n)
-	for i := 0; i < n; i++ {
-		ch, e := src.ReadByte()
-		if e != nil {
-			panic(fmt.Errorf("Tried to Read %d Bytes.. But hit end of file", n))
-		}
-		ret[i] = ch
+	m, err := io.ReadFull(src.Reader, ret)
+	src.off += int64(m)
+	if err != nil {
+		return nil, src.wrapError(h, err)
 	}
-	return ret
+	return ret, nil
 }
 
-func readByte(src *bufio.Reader) byte {
-	b, e := src.ReadByte()
-	if e != nil {
-		panic(fmt.Errorf("Tried to Read 1 Byte.. But hit end of file"))
+// readBreak checks if the next byte of the indefinite length item h is
+// the break code - if so, it is consumed.
+func readBreak(src *cborReader, h head) (bool, error) {
+	pb, err := src.Peek(1)
+	if err != nil {
+		return false, src.wrapError(h, err)
 	}
-	return b
+	if pb[0] == byte(majorTypeSimpleAndFloat|additionalTypeBreak) {
+		src.ReadByte()
+		return true, nil
+	}
+	return false, nil
 }
 
-func decodeIntAdditonalType(src *bufio.Reader, minor byte) int64 {
-	val := int64(0)
+func decodeIntAdditonalType(src *cborReader, h head) (uint64, error) {
+	minor := h.minor()
+	val := uint64(0)
 	if minor <= 23 {
-		val = int64(minor)
+		val = uint64(minor)
 	} else {
 		bytesToRead := 0
 		switch minor {
@@ -83,52 +208,95 @@ func decodeIntAdditonalType(src *bufio.Reader, minor byte) int64 {
 		case additionalTypeIntUint64:
 			bytesToRead = 8
 		default:
-			panic(fmt.Errorf("Invalid Additional Type: %d in decodeInteger (expected <28)", minor))
+			return 0, src.errorf(h, "Invalid Additional Type: %d in decodeInteger (expected <28)", minor)
+		}
+		pb, err := readNBytes(src, h, uint64(bytesToRead))
+		if err != nil {
+			return 0, err
 		}
-		pb := readNBytes(src, bytesToRead)
 		for i := 0; i < bytesToRead; i++ {
 			val = val * 256
-			val += int64(pb[i])
+			val += uint64(pb[i])
 		}
 	}
-	return val
+	return val, nil
 }
 
-func decodeInteger(src *bufio.Reader) int64 {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+// decodeIntegerFull decodes an integer of either sign returning its head
+// and the raw argument. For negative integers the value represented
+// is -1 - val, which covers the whole range down to -2^64.
+func decodeIntegerFull(src *cborReader) (head, uint64, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return h, 0, err
+	}
+	major := h.major()
 	if major != majorTypeUnsignedInt && major != majorTypeNegativeInt {
-		panic(fmt.Errorf("Major type is: %d in decodeInteger!! (expected 0 or 1)", major))
+		return h, 0, src.errorf(h, "Major type is: %d in decodeInteger!! (expected 0 or 1)", major)
 	}
-	val := decodeIntAdditonalType(src, minor)
-	if major == 0 {
-		return val
+	val, err := decodeIntAdditonalType(src, h)
+	return h, val, err
+}
+
+// decodeInteger decodes an integer that is expected to fit in an int64.
+func decodeInteger(src *cborReader) (int64, error) {
+	h, val, err := decodeIntegerFull(src)
+	if err != nil {
+		return 0, err
+	}
+	if val > math.MaxInt64 {
+		return 0, src.errorf(h, "Integer out of int64 range in decodeInteger")
+	}
+	if h.major() == majorTypeUnsignedInt {
+		return int64(val), nil
+	}
+	return (-1 - int64(val)), nil
+}
+
+// appendInteger appends the exact decimal representation of the integer
+// decoded by decodeIntegerFull.
+func appendInteger(dst []byte, major byte, val uint64) []byte {
+	if major == majorTypeUnsignedInt {
+		return strconv.AppendUint(dst, val, 10)
+	}
+	if val == math.MaxUint64 {
+		return append(dst, "-18446744073709551616"...)
 	}
-	return (-1 - val)
+	return strconv.AppendUint(append(dst, '-'), val+1, 10)
 }
 
-func decodeFloat(src *bufio.Reader) (float64, int) {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+func decodeFloat(src *cborReader) (float64, int, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	major := h.major()
+	minor := h.minor()
 	if major != majorTypeSimpleAndFloat {
-		panic(fmt.Errorf("Incorrect Major type is: %d in decodeFloat", major))
+		return 0, 0, src.errorf(h, "Incorrect Major type is: %d in decodeFloat", major)
 	}
 
 	switch minor {
 	case additionalTypeFloat16:
-		panic(fmt.Errorf("float16 is not suppported in decodeFloat"))
+		pb, err := readNBytes(src, h, 2)
+		if err != nil {
+			return 0, 0, err
+		}
+		n := uint16(pb[0])<<8 | uint16(pb[1])
+		return float16ToFloat64(n), isFloat16, nil
 
 	case additionalTypeFloat32:
-		pb := readNBytes(src, 4)
+		pb, err := readNBytes(src, h, 4)
+		if err != nil {
+			return 0, 0, err
+		}
 		switch string(pb) {
 		case float32Nan:
-			return math.NaN(), isFloat32
+			return math.NaN(), isFloat32, nil
 		case float32PosInfinity:
-			return math.Inf(0), isFloat32
+			return math.Inf(0), isFloat32, nil
 		case float32NegInfinity:
-			return math.Inf(-1), isFloat32
+			return math.Inf(-1), isFloat32, nil
 		}
 		n := uint32(0)
 		for i := 0; i < 4; i++ {
@@ -136,16 +304,19 @@ func decodeFloat(src *bufio.Reader) (float64, int) {
 			n += uint32(pb[i])
 		}
 		val := math.Float32frombits(n)
-		return float64(val), isFloat32
+		return float64(val), isFloat32, nil
 	case additionalTypeFloat64:
-		pb := readNBytes(src, 8)
+		pb, err := readNBytes(src, h, 8)
+		if err != nil {
+			return 0, 0, err
+		}
 		switch string(pb) {
 		case float64Nan:
-			return math.NaN(), isFloat64
+			return math.NaN(), isFloat64, nil
 		case float64PosInfinity:
-			return math.Inf(0), isFloat64
+			return math.Inf(0), isFloat64, nil
 		case float64NegInfinity:
-			return math.Inf(-1), isFloat64
+			return math.Inf(-1), isFloat64, nil
 		}
 		n := uint64(0)
 		for i := 0; i < 8; i++ {
@@ -153,9 +324,71 @@ func decodeFloat(src *bufio.Reader) (float64, int) {
 			n += uint64(pb[i])
 		}
 		val := math.Float64frombits(n)
-		return val, isFloat64
+		return val, isFloat64, nil
 	}
-	panic(fmt.Errorf("Invalid Additional Type: %d in decodeFloat", minor))
+	return 0, 0, src.errorf(h, "Invalid Additional Type: %d in decodeFloat", minor)
+}
+
+// float16ToFloat64 converts an IEEE 754 binary16 (half precision) value
+// to float64. Every half precision value (including subnormals, NaN and
+// infinities) is exactly representable as a float64.
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+	var val float64
+	switch exp {
+	case 0:
+		// Zero and subnormals.
+		val = math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac != 0 {
+			return math.NaN()
+		}
+		val = math.Inf(1)
+	default:
+		val = math.Ldexp(frac+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return math.Copysign(val, -1)
+	}
+	return val
+}
+
+// float64ToFloat16 converts f to the nearest IEEE 754 binary16 value
+// (rounding half to even), overflowing to infinity.
+func float64ToFloat16(f float64) uint16 {
+	sign := uint16(math.Float64bits(f)>>48) & 0x8000
+	a := math.Abs(f)
+	switch {
+	case math.IsNaN(f):
+		return sign | 0x7e00
+	case a >= 65520:
+		return sign | 0x7c00
+	case a < math.Ldexp(1, -14):
+		// Subnormal - rounding up to 1024 yields the smallest normal.
+		return sign | uint16(math.RoundToEven(math.Ldexp(a, 24)))
+	}
+	frac, exp := math.Frexp(a)
+	m := math.RoundToEven((frac*2 - 1) * 1024)
+	e := exp + 14
+	if m == 1024 {
+		m = 0
+		e++
+	}
+	return sign | uint16(e)<<10 | uint16(m)
+}
+
+// appendFloat16 appends the shortest decimal representation of v that
+// still decodes back to the same half precision value.
+func appendFloat16(dst []byte, v float64) []byte {
+	h := float64ToFloat16(v)
+	for digits := 0; digits < 5; digits++ {
+		p, err := strconv.ParseFloat(strconv.FormatFloat(v, 'e', digits, 64), 64)
+		if err == nil && float64ToFloat16(p) == h {
+			return strconv.AppendFloat(dst, p, 'f', -1, 64)
+		}
+	}
+	return strconv.AppendFloat(dst, v, 'f', -1, 64)
 }
 
 func decodeStringComplex(dst []byte, s string, pos uint) []byte {
@@ -217,40 +450,91 @@ func decodeStringComplex(dst []byte, s string, pos uint) []byte {
 	return dst
 }
 
-func decodeString(src *bufio.Reader, noQuotes bool) []byte {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
-	if major != majorTypeByteString {
-		panic(fmt.Errorf("Major type is: %d in decodeString", major))
+// readStringData reads the contents of the byte or text string h whose
+// initial byte has already been consumed. Indefinite length strings are
+// returned as the concatenation of all their chunks.
+func readStringData(src *cborReader, h head) ([]byte, error) {
+	if h.minor() != additionalTypeInfiniteCount {
+		length, err := decodeIntAdditonalType(src, h)
+		if err != nil {
+			return nil, err
+		}
+		if err := src.checkLimit(h, "MaxStringLength", length, src.limits.MaxStringLength); err != nil {
+			return nil, err
+		}
+		return readNBytes(src, h, length)
 	}
 	result := []byte{}
-	if !noQuotes {
-		result = append(result, '"')
+	for {
+		isBreak, err := readBreak(src, h)
+		if err != nil {
+			return nil, err
+		}
+		if isBreak {
+			return result, nil
+		}
+		ch, err := readHead(src)
+		if err != nil {
+			return nil, err
+		}
+		if ch.major() != h.major() {
+			return nil, src.errorf(ch, "Major type is: %d in string chunk (expected %d)", ch.major(), h.major())
+		}
+		if ch.minor() == additionalTypeInfiniteCount {
+			return nil, src.errorf(ch, "Nested indefinite length string chunk")
+		}
+		length, err := decodeIntAdditonalType(src, ch)
+		if err != nil {
+			return nil, err
+		}
+		if err := src.checkLimit(h, "MaxStringLength", uint64(len(result))+length, src.limits.MaxStringLength); err != nil {
+			return nil, err
+		}
+		pbs, err := readNBytes(src, ch, length)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, pbs...)
+	}
+}
+
+// decodeString decodes a byte string. If noQuotes is set, the raw bytes
+// are returned, otherwise a JSON string in the current byte encoding.
+func decodeString(src *cborReader, noQuotes bool) ([]byte, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
+	if major != majorTypeByteString {
+		return nil, src.errorf(h, "Major type is: %d in decodeString", major)
+	}
+	pbs, err := readStringData(src, h)
+	if err != nil {
+		return nil, err
 	}
-	length := decodeIntAdditonalType(src, minor)
-	len := int(length)
-	pbs := readNBytes(src, len)
-	result = append(result, pbs...)
 	if noQuotes {
-		return result
+		return pbs, nil
 	}
-	return append(result, '"')
+	return appendByteString([]byte{}, pbs, src.byteEncoding), nil
 }
 
-func decodeUTF8String(src *bufio.Reader) []byte {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+func decodeUTF8String(src *cborReader) ([]byte, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
 	if major != majorTypeUtf8String {
-		panic(fmt.Errorf("Major type is: %d in decodeUTF8String", major))
+		return nil, src.errorf(h, "Major type is: %d in decodeUTF8String", major)
 	}
 	result := []byte{'"'}
-	length := decodeIntAdditonalType(src, minor)
-	len := int(length)
-	pbs := readNBytes(src, len)
+	pbs, err := readStringData(src, h)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < len; i++ {
+	for i := 0; i < len(pbs); i++ {
 		// Check if the character needs encoding. Control characters, slashes,
 		// and the double quote need json encoding. Bytes above the ascii
 		// boundary needs utf8 encoding.
@@ -259,312 +543,359 @@ func decodeUTF8String(src *bufio.Reader) []byte {
 			// to complex version of the algorithm.
 			dst := []byte{'"'}
 			dst = decodeStringComplex(dst, string(pbs), uint(i))
-			return append(dst, '"')
+			return append(dst, '"'), nil
 		}
 	}
 	// The string has no need for encoding an therefore is directly
 	// appended to the byte slice.
 	result = append(result, pbs...)
-	return append(result, '"')
+	return append(result, '"'), nil
 }
 
-func array2Json(src *bufio.Reader, dst io.Writer) {
-	dst.Write([]byte{'['})
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+// decodeContainerLength returns the number of elements (or pairs) of the
+// array or map h; unSpecifiedCount is set for indefinite length items.
+func decodeContainerLength(src *cborReader, h head) (len int, unSpecifiedCount bool, err error) {
+	if h.minor() == additionalTypeInfiniteCount {
+		return 0, true, nil
+	}
+	length, err := decodeIntAdditonalType(src, h)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := src.checkLimit(h, "MaxElements", length, src.limits.MaxElements); err != nil {
+		return 0, false, err
+	}
+	if length > maxInt {
+		return 0, false, src.errorf(h, "Length %d too large", length)
+	}
+	return int(length), false, nil
+}
+
+// readNextElement is called before each element (or pair) i of the
+// array or map h. It reports if the container has no more elements.
+func readNextElement(src *cborReader, h head, i int, len int, unSpecifiedCount bool) (bool, error) {
+	if !unSpecifiedCount {
+		return i >= len, nil
+	}
+	isBreak, err := readBreak(src, h)
+	if err != nil || isBreak {
+		return isBreak, err
+	}
+	return false, src.checkLimit(h, "MaxElements", uint64(i)+1, src.limits.MaxElements)
+}
+
+func array2Json(src *cborReader, dst io.Writer) error {
+	h, err := readHead(src)
+	if err != nil {
+		return err
+	}
+	major := h.major()
 	if major != majorTypeArray {
-		panic(fmt.Errorf("Major type is: %d in array2Json", major))
+		return src.errorf(h, "Major type is: %d in array2Json", major)
 	}
-	len := 0
-	unSpecifiedCount := false
-	if minor == additionalTypeInfiniteCount {
-		unSpecifiedCount = true
-	} else {
-		length := decodeIntAdditonalType(src, minor)
-		len = int(length)
-	}
-	for i := 0; unSpecifiedCount || i < len; i++ {
-		if unSpecifiedCount {
-			pb, e := src.Peek(1)
-			if e != nil {
-				panic(e)
-			}
-			if pb[0] == byte(majorTypeSimpleAndFloat|additionalTypeBreak) {
-				readByte(src)
-				break
-			}
+	if err := src.enter(h); err != nil {
+		return err
+	}
+	defer src.leave()
+	len, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return err
+	}
+	dst.Write([]byte{'['})
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, len, unSpecifiedCount)
+		if err != nil {
+			return err
 		}
-		cbor2JsonOneObject(src, dst)
-		if unSpecifiedCount {
-			pb, e := src.Peek(1)
-			if e != nil {
-				panic(e)
-			}
-			if pb[0] == byte(majorTypeSimpleAndFloat|additionalTypeBreak) {
-				readByte(src)
-				break
-			}
-			dst.Write([]byte{','})
-		} else if i+1 < len {
+		if done {
+			break
+		}
+		if i > 0 {
 			dst.Write([]byte{','})
 		}
+		if err := cbor2JsonOneObject(src, dst); err != nil {
+			return prependPath(err, indexPath(i))
+		}
 	}
 	dst.Write([]byte{']'})
+	return nil
 }
 
-func map2Json(src *bufio.Reader, dst io.Writer) {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+// keyPath returns the path element of a map value given its JSON key.
+func keyPath(key []byte) string {
+	if n := len(key); n >= 2 && key[0] == '"' && key[n-1] == '"' {
+		key = key[1 : n-1]
+	}
+	return "." + string(key)
+}
+
+func map2Json(src *cborReader, dst io.Writer) error {
+	h, err := readHead(src)
+	if err != nil {
+		return err
+	}
+	major := h.major()
 	if major != majorTypeMap {
-		panic(fmt.Errorf("Major type is: %d in map2Json", major))
+		return src.errorf(h, "Major type is: %d in map2Json", major)
 	}
-	len := 0
-	unSpecifiedCount := false
-	if minor == additionalTypeInfiniteCount {
-		unSpecifiedCount = true
-	} else {
-		length := decodeIntAdditonalType(src, minor)
-		len = int(length) * 2
+	if err := src.enter(h); err != nil {
+		return err
+	}
+	defer src.leave()
+	len, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return err
+	}
+	if src.opts.ComplexKeysAsPairs {
+		return mapPairs2Json(src, h, len, unSpecifiedCount, dst)
 	}
 	dst.Write([]byte{'{'})
-	for i := 0; unSpecifiedCount || i < len; i++ {
-		if unSpecifiedCount {
-			pb, e := src.Peek(1)
-			if e != nil {
-				panic(e)
-			}
-			if pb[0] == byte(majorTypeSimpleAndFloat|additionalTypeBreak) {
-				readByte(src)
-				break
-			}
+	var key bytes.Buffer
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, len, unSpecifiedCount)
+		if err != nil {
+			return err
 		}
-		cbor2JsonOneObject(src, dst)
-		if i%2 == 0 {
-			// Even position values are keys.
-			dst.Write([]byte{':'})
-		} else {
-			if unSpecifiedCount {
-				pb, e := src.Peek(1)
-				if e != nil {
-					panic(e)
-				}
-				if pb[0] == byte(majorTypeSimpleAndFloat|additionalTypeBreak) {
-					readByte(src)
-					break
-				}
-				dst.Write([]byte{','})
-			} else if i+1 < len {
-				dst.Write([]byte{','})
-			}
+		if done {
+			break
+		}
+		if i > 0 {
+			dst.Write([]byte{','})
+		}
+		key.Reset()
+		if err := cbor2JsonOneObject(src, &key); err != nil {
+			return keyError(err)
+		}
+		k := jsonObjectKey(key.Bytes())
+		dst.Write(k)
+		dst.Write([]byte{':'})
+		if err := cbor2JsonOneObject(src, dst); err != nil {
+			return prependPath(err, keyPath(k))
 		}
 	}
 	dst.Write([]byte{'}'})
+	return nil
 }
 
-func decodeTagData(src *bufio.Reader) []byte {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
-	if major != majorTypeTags {
-		panic(fmt.Errorf("Major type is: %d in decodeTagData", major))
-	}
-	switch minor {
-	case additionalTypeTimestamp:
-		return decodeTimeStamp(src)
-
-	// Tag value is larger than 256 (so uint16).
-	case additionalTypeIntUint16:
-		val := decodeIntAdditonalType(src, minor)
-
-		switch uint16(val) {
-		case additionalTypeEmbeddedJSON:
-			pb := readByte(src)
-			dataMajor := pb & maskOutAdditionalType
-			if dataMajor != majorTypeByteString {
-				panic(fmt.Errorf("Unsupported embedded Type: %d in decodeEmbeddedJSON", dataMajor))
-			}
-			src.UnreadByte()
-			return decodeString(src, true)
-
-		case additionalTypeTagNetworkAddr:
-			octets := decodeString(src, true)
-			ss := []byte{'"'}
-			switch len(octets) {
-			case 6: // MAC address.
-				ha := net.HardwareAddr(octets)
-				ss = append(append(ss, ha.String()...), '"')
-			case 4: // IPv4 address.
-				fallthrough
-			case 16: // IPv6 address.
-				ip := net.IP(octets)
-				ss = append(append(ss, ip.String()...), '"')
-			default:
-				panic(fmt.Errorf("Unexpected Network Address length: %d (expected 4,6,16)", len(octets)))
-			}
-			return ss
+// isComplexKey reports if the map key rendered as JSON key is an array or
+// an object.
+func isComplexKey(key []byte) bool {
+	return len(key) > 0 && (key[0] == '[' || key[0] == '{')
+}
 
-		case additionalTypeTagNetworkPrefix:
-			pb := readByte(src)
-			if pb != byte(majorTypeMap|0x1) {
-				panic(fmt.Errorf("IP Prefix is NOT of MAP of 1 elements as expected"))
-			}
-			octets := decodeString(src, true)
-			val := decodeInteger(src)
-			ip := net.IP(octets)
-			var mask net.IPMask
-			pfxLen := int(val)
-			if len(octets) == 4 {
-				mask = net.CIDRMask(pfxLen, 32)
-			} else {
-				mask = net.CIDRMask(pfxLen, 128)
-			}
-			ipPfx := net.IPNet{IP: ip, Mask: mask}
-			ss := []byte{'"'}
-			ss = append(append(ss, ipPfx.String()...), '"')
-			return ss
-
-		case additionalTypeTagHexString:
-			octets := decodeString(src, true)
-			ss := []byte{'"'}
-			for _, v := range octets {
-				ss = append(ss, hexTable[v>>4], hexTable[v&0x0f])
-			}
-			return append(ss, '"')
+// jsonObjectKey returns the map key rendered as JSON key as a JSON string:
+// numbers, true, false and null become strings, arrays and objects
+// strings holding their JSON text.
+func jsonObjectKey(key []byte) []byte {
+	if len(key) > 0 && key[0] == '"' {
+		return key
+	}
+	k := []byte{'"'}
+	k = decodeStringComplex(k, string(key), 0)
+	return append(k, '"')
+}
 
-		default:
-			panic(fmt.Errorf("Unsupported Additional Tag Type: %d in decodeTagData", val))
+// mapPairs2Json renders the map h as a JSON object if all its keys are
+// scalars, otherwise as an array of [key,value] pairs.
+func mapPairs2Json(src *cborReader, h head, len int, unSpecifiedCount bool, dst io.Writer) error {
+	var obj, pairs, key, val bytes.Buffer
+	complexKeys := false
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, len, unSpecifiedCount)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
 		}
+		if i > 0 {
+			obj.WriteByte(',')
+			pairs.WriteByte(',')
+		}
+		key.Reset()
+		val.Reset()
+		if err := cbor2JsonOneObject(src, &key); err != nil {
+			return keyError(err)
+		}
+		k := jsonObjectKey(key.Bytes())
+		if err := cbor2JsonOneObject(src, &val); err != nil {
+			return prependPath(err, keyPath(k))
+		}
+		complexKeys = complexKeys || isComplexKey(key.Bytes())
+		obj.Write(k)
+		obj.WriteByte(':')
+		obj.Write(val.Bytes())
+		pairs.WriteByte('[')
+		pairs.Write(key.Bytes())
+		pairs.WriteByte(',')
+		pairs.Write(val.Bytes())
+		pairs.WriteByte(']')
+	}
+	if complexKeys {
+		dst.Write([]byte{'['})
+		dst.Write(pairs.Bytes())
+		dst.Write([]byte{']'})
+	} else {
+		dst.Write([]byte{'{'})
+		dst.Write(obj.Bytes())
+		dst.Write([]byte{'}'})
 	}
-	panic(fmt.Errorf("Unsupported Additional Type: %d in decodeTagData", minor))
+	return nil
 }
 
-func decodeTimeStamp(src *bufio.Reader) []byte {
-	pb := readByte(src)
-	src.UnreadByte()
-	tsMajor := pb & maskOutAdditionalType
-	if tsMajor == majorTypeUnsignedInt || tsMajor == majorTypeNegativeInt {
-		n := decodeInteger(src)
-		t := time.Unix(n, 0)
-		if DecodeTimeZone != nil {
-			t = t.In(DecodeTimeZone)
-		} else {
-			t = t.In(time.UTC)
-		}
-		tsb := []byte{}
-		tsb = append(tsb, '"')
-		tsb = t.AppendFormat(tsb, IntegerTimeFieldFormat)
-		tsb = append(tsb, '"')
-		return tsb
-	} else if tsMajor == majorTypeSimpleAndFloat {
-		n, _ := decodeFloat(src)
-		secs := int64(n)
-		n -= float64(secs)
-		n *= float64(1e9)
-		t := time.Unix(secs, int64(n))
-		if DecodeTimeZone != nil {
-			t = t.In(DecodeTimeZone)
-		} else {
-			t = t.In(time.UTC)
+func decodeTagData(src *cborReader) ([]byte, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
+	if major != majorTypeTags {
+		return nil, src.errorf(h, "Major type is: %d in decodeTagData", major)
+	}
+	tag, err := decodeIntAdditonalType(src, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := src.enter(h); err != nil {
+		return nil, err
+	}
+	defer src.leave()
+	if th, ok := src.opts.tagHandler(tag); ok && th.Render != nil {
+		content, err := unmarshalOneObject(src)
+		if err != nil {
+			return nil, err
+		}
+		ba, err := th.Render(content, src.opts)
+		if err != nil {
+			return nil, src.wrapError(h, err)
 		}
-		tsb := []byte{}
-		tsb = append(tsb, '"')
-		tsb = t.AppendFormat(tsb, NanoTimeFieldFormat)
-		tsb = append(tsb, '"')
-		return tsb
+		return ba, nil
 	}
-	panic(fmt.Errorf("TS format is neigther int nor float: %d", tsMajor))
+	if enc, ok := expectedConversions[tag]; ok {
+		return decodeExpectedConversion(src, enc)
+	}
+	return decodeUnknownTag(src, tag)
 }
 
-func decodeSimpleFloat(src *bufio.Reader) []byte {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+// decodeExpectedConversion renders the content of an expected conversion
+// tag with its byte strings in the encoding enc.
+func decodeExpectedConversion(src *cborReader, enc ByteEncoding) ([]byte, error) {
+	saved := src.byteEncoding
+	src.byteEncoding = enc
+	defer func() { src.byteEncoding = saved }()
+	var b bytes.Buffer
+	if err := cbor2JsonOneObject(src, &b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decodeUnknownTag renders a tag csd does not understand as
+// {"@tag":N,"@value":...} with the tag content decoded as usual.
+func decodeUnknownTag(src *cborReader, tag uint64) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(`{"@tag":`)
+	b.WriteString(strconv.FormatUint(tag, 10))
+	b.WriteString(`,"@value":`)
+	if err := cbor2JsonOneObject(src, &b); err != nil {
+		return nil, err
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func decodeSimpleFloat(src *cborReader) ([]byte, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
+	minor := h.minor()
 	if major != majorTypeSimpleAndFloat {
-		panic(fmt.Errorf("Major type is: %d in decodeSimpleFloat", major))
+		return nil, src.errorf(h, "Major type is: %d in decodeSimpleFloat", major)
 	}
 	switch minor {
 	case additionalTypeBoolTrue:
-		return []byte("true")
+		return []byte("true"), nil
 	case additionalTypeBoolFalse:
-		return []byte("false")
+		return []byte("false"), nil
 	case additionalTypeNull:
-		return []byte("null")
+		return []byte("null"), nil
 	case additionalTypeFloat16:
 		fallthrough
 	case additionalTypeFloat32:
 		fallthrough
 	case additionalTypeFloat64:
 		src.UnreadByte()
-		v, bc := decodeFloat(src)
+		v, bc, err := decodeFloat(src)
+		if err != nil {
+			return nil, err
+		}
 		ba := []byte{}
 		switch {
 		case math.IsNaN(v):
-			return []byte("\"NaN\"")
+			return []byte("\"NaN\""), nil
 		case math.IsInf(v, 1):
-			return []byte("\"+Inf\"")
+			return []byte("\"+Inf\""), nil
 		case math.IsInf(v, -1):
-			return []byte("\"-Inf\"")
+			return []byte("\"-Inf\""), nil
 		}
-		if bc == isFloat32 {
+		if bc == isFloat16 {
+			ba = appendFloat16(ba, v)
+		} else if bc == isFloat32 {
 			ba = strconv.AppendFloat(ba, v, 'f', -1, 32)
-		} else if bc == isFloat64 {
-			ba = strconv.AppendFloat(ba, v, 'f', -1, 64)
 		} else {
-			panic(fmt.Errorf("Invalid Float precision from decodeFloat: %d", bc))
+			ba = strconv.AppendFloat(ba, v, 'f', -1, 64)
 		}
-		return ba
+		return ba, nil
 	default:
-		panic(fmt.Errorf("Invalid Additional Type: %d in decodeSimpleFloat", minor))
+		return nil, src.errorf(h, "Invalid Additional Type: %d in decodeSimpleFloat", minor)
 	}
 }
 
-func cbor2JsonOneObject(src *bufio.Reader, dst io.Writer) {
+func cbor2JsonOneObject(src *cborReader, dst io.Writer) error {
 	pb, e := src.Peek(1)
 	if e != nil {
-		panic(e)
+		return src.eofError(e)
 	}
 	major := (pb[0] & maskOutAdditionalType)
 
+	var s []byte
+	var err error
 	switch major {
 	case majorTypeUnsignedInt:
 		fallthrough
 	case majorTypeNegativeInt:
-		n := decodeInteger(src)
-		dst.Write([]byte(strconv.Itoa(int(n))))
+		var h head
+		var val uint64
+		h, val, err = decodeIntegerFull(src)
+		if err == nil {
+			s = appendInteger([]byte{}, h.major(), val)
+		}
 
 	case majorTypeByteString:
-		s := decodeString(src, false)
-		dst.Write(s)
+		s, err = decodeString(src, false)
 
 	case majorTypeUtf8String:
-		s := decodeUTF8String(src)
-		dst.Write(s)
+		s, err = decodeUTF8String(src)
 
 	case majorTypeArray:
-		array2Json(src, dst)
+		return array2Json(src, dst)
 
 	case majorTypeMap:
-		map2Json(src, dst)
+		return map2Json(src, dst)
 
 	case majorTypeTags:
-		s := decodeTagData(src)
-		dst.Write(s)
+		s, err = decodeTagData(src)
 
 	case majorTypeSimpleAndFloat:
-		s := decodeSimpleFloat(src)
-		dst.Write(s)
+		s, err = decodeSimpleFloat(src)
 	}
-}
-
-func moreBytesToRead(src *bufio.Reader) bool {
-	_, e := src.ReadByte()
-	if e == nil {
-		src.UnreadByte()
-		return true
+	if err != nil {
+		return err
 	}
-	return false
+	dst.Write(s)
+	return nil
 }
 
 // Cbor2JsonManyObjects decodes all the CBOR Objects read from src
@@ -572,24 +903,34 @@ func moreBytesToRead(src *bufio.Reader) bool {
 // Decoded string is written to the dst. At the end of every CBOR Object
 // newline is written to the output stream.
 //
-// Returns error (if any) that was encountered during decode.
-// The child functions will generate a panic when error is encountered and
-// this function will recover non-runtime Errors and return the reason as error.
-func Cbor2JsonManyObjects(src io.Reader, dst io.Writer) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
+// Returns error (if any) that was encountered during decode - a
+// *DecodeError if the input is malformed or truncated, or the error
+// returned by src (other than io.EOF) between objects.
+func Cbor2JsonManyObjects(src io.Reader, dst io.Writer) error {
+	return cbor2JsonManyObjects(newCborReader(src, resolveOptions(nil)), dst)
+}
+
+// Cbor2JsonManyObjectsOptions is the same as Cbor2JsonManyObjects, using
+// opts to decode.
+func Cbor2JsonManyObjectsOptions(src io.Reader, dst io.Writer, opts DecoderOptions) error {
+	return cbor2JsonManyObjects(newCborReader(src, resolveOptions([]DecoderOptions{opts})), dst)
+}
+
+func cbor2JsonManyObjects(rdr *cborReader, dst io.Writer) error {
+	for {
+		if _, err := rdr.Peek(1); err != nil {
+			if err == io.EOF {
+				return nil
 			}
-			err = r.(error)
+			return err
+		}
+		rdr.startRecord()
+		if err := cbor2JsonOneObject(rdr, dst); err != nil {
+			return err
 		}
-	}()
-	bufRdr := bufio.NewReader(src)
-	for moreBytesToRead(bufRdr) {
-		cbor2JsonOneObject(bufRdr, dst)
 		dst.Write([]byte("\n"))
+		rdr.record++
 	}
-	return nil
 }
 
 // Detect if the bytes to be printed is Binary or not.
@@ -600,8 +941,8 @@ func binaryFmt(p []byte) bool {
 	return false
 }
 
-func getReader(str string) *bufio.Reader {
-	return bufio.NewReader(strings.NewReader(str))
+func getReader(str string) *cborReader {
+	return newCborReader(strings.NewReader(str), resolveOptions(nil))
 }
 
 // DecodeIfBinaryToString converts a binary formatted log msg to a
@@ -3,7 +3,10 @@ package csd
 import (
 	"bytes"
 	"encoding/hex"
+	"io"
+	"math"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -72,7 +75,10 @@ func TestDecodeInteger(t *testing.T) {
 		{-1000000000001, "\x3b\x00\x00\x00\xe8\xd4\xa5\x10\x00"},
 	}
 	for _, tc := range integerTestCases {
-		gotv := decodeInteger(getReader(tc.binary))
+		gotv, err := decodeInteger(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeInteger(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if gotv != int64(tc.val) {
 			t.Errorf("decodeInteger(0x%s)=0x%d, want: 0x%d",
 				hex.EncodeToString([]byte(tc.binary)), gotv, tc.val)
@@ -80,6 +86,31 @@ func TestDecodeInteger(t *testing.T) {
 	}
 }
 
+func TestDecodeIntegerRange(t *testing.T) {
+	var integerRangeTestCases = []struct {
+		json   string
+		binary string
+	}{
+		{"9223372036854775807", "\x1b\x7f\xff\xff\xff\xff\xff\xff\xff"},
+		{"9223372036854775808", "\x1b\x80\x00\x00\x00\x00\x00\x00\x00"},
+		{"18446744073709551615", "\x1b\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{"-9223372036854775808", "\x3b\x7f\xff\xff\xff\xff\xff\xff\xff"},
+		{"-9223372036854775809", "\x3b\x80\x00\x00\x00\x00\x00\x00\x00"},
+		{"-18446744073709551615", "\x3b\xff\xff\xff\xff\xff\xff\xff\xfe"},
+		{"-18446744073709551616", "\x3b\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{"4294967296", "\x1b\x00\x00\x00\x01\x00\x00\x00\x00"},
+	}
+	for _, tc := range integerRangeTestCases {
+		buf := bytes.NewBuffer([]byte{})
+		if err := cbor2JsonOneObject(getReader(tc.binary), buf); err != nil {
+			t.Fatalf("cbor2JsonOneObject(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if buf.String() != tc.json {
+			t.Errorf("cbor2JsonOneObject(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), buf.String(), tc.json)
+		}
+	}
+}
+
 func TestDecodeString(t *testing.T) {
 	var encodeStringTests = []struct {
 		plain  string
@@ -110,7 +141,10 @@ func TestDecodeString(t *testing.T) {
 	}
 
 	for _, tt := range encodeStringTests {
-		got := decodeUTF8String(getReader(tt.binary))
+		got, err := decodeUTF8String(getReader(tt.binary))
+		if err != nil {
+			t.Fatalf("decodeUTF8String(0x%s) failed: %v", hex.EncodeToString([]byte(tt.binary)), err)
+		}
 		if string(got) != "\""+tt.json+"\"" {
 			t.Errorf("DecodeString(0x%s)=%s, want:\"%s\"\n", hex.EncodeToString([]byte(tt.binary)), string(got),
 				hex.EncodeToString([]byte(tt.json)))
@@ -118,6 +152,45 @@ func TestDecodeString(t *testing.T) {
 	}
 }
 
+func TestDecodeIndefiniteString(t *testing.T) {
+	var indefiniteStringTests = []struct {
+		binary string
+		json   string
+	}{
+		{"\x7f\xff", "\"\""},
+		{"\x7f\x65strea\x64ming\xff", "\"streaming\""},
+		{"\x7f\x62a\"\x60\x63\\bc\xff", "\"a\\\"\\\\bc\""},
+		{"\x7f\x78\x02IE\x62TF\xff", "\"IETF\""},
+	}
+	for _, tt := range indefiniteStringTests {
+		got, err := decodeUTF8String(getReader(tt.binary))
+		if err != nil {
+			t.Fatalf("decodeUTF8String(0x%s) failed: %v", hex.EncodeToString([]byte(tt.binary)), err)
+		}
+		if string(got) != tt.json {
+			t.Errorf("decodeUTF8String(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tt.binary)), got, tt.json)
+		}
+	}
+
+	var indefiniteByteStringTests = []struct {
+		binary string
+		plain  string
+	}{
+		{"\x5f\xff", ""},
+		{"\x5f\x42\x01\x02\x43\x03\x04\x05\xff", "\x01\x02\x03\x04\x05"},
+	}
+	for _, tt := range indefiniteByteStringTests {
+		got, err := decodeString(getReader(tt.binary), true)
+		if err != nil {
+			t.Fatalf("decodeString(0x%s) failed: %v", hex.EncodeToString([]byte(tt.binary)), err)
+		}
+		if string(got) != tt.plain {
+			t.Errorf("decodeString(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tt.binary)),
+				hex.EncodeToString(got), hex.EncodeToString([]byte(tt.plain)))
+		}
+	}
+}
+
 func TestDecodeArray(t *testing.T) {
 	var integerArrayTestCases = []struct {
 		val    []int
@@ -133,7 +206,9 @@ func TestDecodeArray(t *testing.T) {
 	}
 	for _, tc := range integerArrayTestCases {
 		buf := bytes.NewBuffer([]byte{})
-		array2Json(getReader(tc.binary), buf)
+		if err := array2Json(getReader(tc.binary), buf); err != nil {
+			t.Fatalf("array2Json(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if buf.String() != tc.json {
 			t.Errorf("array2Json(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), buf.String(), tc.json)
 		}
@@ -151,7 +226,9 @@ func TestDecodeArray(t *testing.T) {
 	}
 	for _, tc := range infiniteArrayTestCases {
 		buf := bytes.NewBuffer([]byte{})
-		array2Json(getReader(tc.in), buf)
+		if err := array2Json(getReader(tc.in), buf); err != nil {
+			t.Fatalf("array2Json(0x%s) failed: %v", hex.EncodeToString([]byte(tc.in)), err)
+		}
 		if buf.String() != tc.out {
 			t.Errorf("array2Json(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.out)), buf.String(), tc.out)
 		}
@@ -167,7 +244,9 @@ func TestDecodeArray(t *testing.T) {
 	}
 	for _, tc := range booleanArrayTestCases {
 		buf := bytes.NewBuffer([]byte{})
-		array2Json(getReader(tc.binary), buf)
+		if err := array2Json(getReader(tc.binary), buf); err != nil {
+			t.Fatalf("array2Json(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if buf.String() != tc.json {
 			t.Errorf("array2Json(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), buf.String(), tc.json)
 		}
@@ -194,20 +273,57 @@ var mapDecodeTestCases = []struct {
 func TestDecodeMap(t *testing.T) {
 	for _, tc := range mapDecodeTestCases {
 		buf := bytes.NewBuffer([]byte{})
-		map2Json(getReader(string(tc.bin)), buf)
+		if err := map2Json(getReader(string(tc.bin)), buf); err != nil {
+			t.Fatalf("map2Json(0x%s) failed: %v", hex.EncodeToString(tc.bin), err)
+		}
 		if buf.String() != tc.json {
 			t.Errorf("map2Json(0x%s)=%s, want: %s", hex.EncodeToString(tc.bin), buf.String(), tc.json)
 		}
 	}
 	for _, tc := range infiniteMapDecodeTestCases {
 		buf := bytes.NewBuffer([]byte{})
-		map2Json(getReader(string(tc.bin)), buf)
+		if err := map2Json(getReader(string(tc.bin)), buf); err != nil {
+			t.Fatalf("map2Json(0x%s) failed: %v", hex.EncodeToString(tc.bin), err)
+		}
 		if buf.String() != tc.json {
 			t.Errorf("map2Json(0x%s)=%s, want: %s", hex.EncodeToString(tc.bin), buf.String(), tc.json)
 		}
 	}
 }
 
+func TestDecodeMapKeys(t *testing.T) {
+	var mapKeyTestCases = []struct {
+		bin   string
+		json  string
+		pairs string
+	}{
+		{"\xa2\x01\x61x\x20\x61y", "{\"1\":\"x\",\"-1\":\"y\"}", "{\"1\":\"x\",\"-1\":\"y\"}"},
+		{"\xa3\xf5\x01\xf6\x02\xf9\x3e\x00\x03", "{\"true\":1,\"null\":2,\"1.5\":3}", "{\"true\":1,\"null\":2,\"1.5\":3}"},
+		{"\xa1\xc1\x00\x01", "{\"1970-01-01T00:00:00Z\":1}", "{\"1970-01-01T00:00:00Z\":1}"},
+		{"\xa1\x42\x01\x02\x01", "{\"AQI=\":1}", "{\"AQI=\":1}"},
+		{"\xa2\x01\x02\x82\x01\x02\x03", "{\"1\":2,\"[1,2]\":3}", "[[1,2],[[1,2],3]]"},
+		{"\xa1\xa1\x61a\x01\xf4", "{\"{\\\"a\\\":1}\":false}", "[[{\"a\":1},false]]"},
+		{"\xa1\x01\xa1\x82\x01\x02\x03", "{\"1\":{\"[1,2]\":3}}", "{\"1\":[[[1,2],3]]}"},
+	}
+	for _, tc := range mapKeyTestCases {
+		buf := bytes.NewBuffer([]byte{})
+		if err := map2Json(getReader(tc.bin), buf); err != nil {
+			t.Fatalf("map2Json(0x%s) failed: %v", hex.EncodeToString([]byte(tc.bin)), err)
+		}
+		if buf.String() != tc.json {
+			t.Errorf("map2Json(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.bin)), buf.String(), tc.json)
+		}
+		buf.Reset()
+		rdr := newCborReader(strings.NewReader(tc.bin), resolveOptions([]DecoderOptions{{ComplexKeysAsPairs: true}}))
+		if err := map2Json(rdr, buf); err != nil {
+			t.Fatalf("map2Json(0x%s) failed: %v", hex.EncodeToString([]byte(tc.bin)), err)
+		}
+		if buf.String() != tc.pairs {
+			t.Errorf("map2Json(0x%s) with pairs=%s, want: %s", hex.EncodeToString([]byte(tc.bin)), buf.String(), tc.pairs)
+		}
+	}
+}
+
 func TestDecodeBool(t *testing.T) {
 	var booleanTestCases = []struct {
 		val    bool
@@ -218,7 +334,10 @@ func TestDecodeBool(t *testing.T) {
 		{false, "\xf4", "false"},
 	}
 	for _, tc := range booleanTestCases {
-		got := decodeSimpleFloat(getReader(tc.binary))
+		got, err := decodeSimpleFloat(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeSimpleFloat(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if string(got) != tc.json {
 			t.Errorf("decodeSimpleFloat(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tc.binary)), string(got), tc.json)
 		}
@@ -239,13 +358,56 @@ func TestDecodeFloat(t *testing.T) {
 	}
 
 	for _, tc := range float32TestCases {
-		got := decodeSimpleFloat(getReader(tc.binary))
+		got, err := decodeSimpleFloat(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeSimpleFloat(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if string(got) != tc.val {
+			t.Errorf("decodeFloat(0x%s)=%s, want:%s\n", hex.EncodeToString([]byte(tc.binary)), got, tc.val)
+		}
+	}
+
+	var float16TestCases = []struct {
+		val    string
+		binary string
+	}{
+		{"0", "\xf9\x00\x00"},
+		{"-0", "\xf9\x80\x00"},
+		{"1", "\xf9\x3c\x00"},
+		{"1.5", "\xf9\x3e\x00"},
+		{"0.1", "\xf9\x2e\x66"},
+		{"65500", "\xf9\x7b\xff"},
+		{"-4", "\xf9\xc4\x00"},
+		{"0.00006104", "\xf9\x04\x00"},
+		{"0.00000006", "\xf9\x00\x01"},
+		{"\"+Inf\"", "\xf9\x7c\x00"},
+		{"\"-Inf\"", "\xf9\xfc\x00"},
+		{"\"NaN\"", "\xf9\x7e\x00"},
+	}
+
+	for _, tc := range float16TestCases {
+		got, err := decodeSimpleFloat(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeSimpleFloat(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if string(got) != tc.val {
 			t.Errorf("decodeFloat(0x%s)=%s, want:%s\n", hex.EncodeToString([]byte(tc.binary)), got, tc.val)
 		}
 	}
 }
 
+func TestFloat16RoundTrip(t *testing.T) {
+	for h := 0; h <= 0xffff; h++ {
+		v := float16ToFloat64(uint16(h))
+		if math.IsNaN(v) {
+			continue
+		}
+		if got := float64ToFloat16(v); got != uint16(h) {
+			t.Errorf("float64ToFloat16(%v)=0x%04x, want: 0x%04x", v, got, h)
+		}
+	}
+}
+
 func TestDecodeTimestamp(t *testing.T) {
 	var timeIntegerTestcases = []struct {
 		txt    string
@@ -257,7 +419,10 @@ func TestDecodeTimestamp(t *testing.T) {
 	}
 	DecodeTimeZone, _ = time.LoadLocation("UTC")
 	for _, tc := range timeIntegerTestcases {
-		tm := decodeTagData(getReader(tc.binary))
+		tm, err := decodeTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if string(tm) != "\""+tc.rfcStr+"\"" {
 			t.Errorf("decodeFloat(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tc.binary)), tm, tc.rfcStr)
 		}
@@ -270,7 +435,10 @@ func TestDecodeTimestamp(t *testing.T) {
 		{"1956-01-02T15:04:05.999999-08:00", "\xc1\xfb\xc1\xba\x53\x81\x1a\x00\x00\x11"},
 	}
 	for _, tc := range timeFloatTestcases {
-		tm := decodeTagData(getReader(tc.out))
+		tm, err := decodeTagData(getReader(tc.out))
+		if err != nil {
+			t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.out)), err)
+		}
 		//Since we convert to float and back - it may be slightly off - so
 		//we cannot check for exact equality instead, we'll check it is
 		//very close to each other Less than a Microsecond (lets not yet do nanosec)
@@ -295,7 +463,10 @@ func TestDecodeNetworkAddr(t *testing.T) {
 			"\xd9\x01\x04\x50\x20\x01\x0d\xb8\x85\xa3\x00\x00\x00\x00\x8a\x2e\x03\x70\x73\x34"},
 	}
 	for _, tc := range ipAddrTestCases {
-		d1 := decodeTagData(getReader(tc.binary))
+		d1, err := decodeTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if string(d1) != tc.text {
 			t.Errorf("decodeNetworkAddr(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tc.binary)), d1, tc.text)
 		}
@@ -313,7 +484,10 @@ func TestDecodeMACAddr(t *testing.T) {
 	}
 
 	for _, tc := range macAddrTestCases {
-		d1 := decodeTagData(getReader(tc.binary))
+		d1, err := decodeTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if string(d1) != tc.text {
 			t.Errorf("decodeNetworkAddr(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tc.binary)), d1, tc.text)
 		}
@@ -332,13 +506,114 @@ func TestDecodeIPPrefix(t *testing.T) {
 	}
 
 	for _, tc := range IPPrefixTestCases {
-		d1 := decodeTagData(getReader(tc.binary))
+		d1, err := decodeTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if string(d1) != tc.text {
 			t.Errorf("decodeIPPrefix(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tc.binary)), d1, tc.text)
 		}
 	}
 }
 
+func TestDecodeBigNumbers(t *testing.T) {
+	var bigNumberTestCases = []struct {
+		json   string
+		binary string
+	}{
+		// Bignums.
+		{"18446744073709551616", "\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{"-18446744073709551617", "\xc3\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{"0", "\xc2\x40"},
+		{"-1", "\xc3\x40"},
+		// Decimal fractions.
+		{"273.15", "\xc4\x82\x21\x19\x6a\xb3"},
+		{"-0.05", "\xc4\x82\x21\x24"},
+		{"0.000001", "\xc4\x82\x25\x01"},
+		{"15e3", "\xc4\x82\x03\x0f"},
+		{"1e-20", "\xc4\x82\x33\x01"},
+		{"184467440737.09551616", "\xc4\x82\x27\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{"1e-9223372036854775808", "\xc4\x82\x3b\x7f\xff\xff\xff\xff\xff\xff\xff\x01"},
+		{"1e9223372036854775807", "\xc4\x82\x1b\x7f\xff\xff\xff\xff\xff\xff\xff\x01"},
+		// Bigfloats.
+		{"1.5", "\xc5\x82\x20\x03"},
+		{"-0.125", "\xc5\x82\x22\x20"},
+		{"96", "\xc5\x82\x05\x03"},
+		// Beyond maxExactBigfloatExponent, the tag as is.
+		{"{\"@tag\":5,\"@value\":[4097,1]}", "\xc5\x82\x19\x10\x01\x01"},
+		{"{\"@tag\":5,\"@value\":[-4097,-3]}", "\xc5\x82\x39\x10\x00\x22"},
+	}
+	for _, tc := range bigNumberTestCases {
+		got, err := decodeTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if string(got) != tc.json {
+			t.Errorf("decodeTagData(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), got, tc.json)
+		}
+	}
+
+	DecodeBigNumbersAsString = true
+	defer func() { DecodeBigNumbersAsString = false }()
+	got, err := decodeTagData(getReader("\xc4\x82\x21\x19\x6a\xb3"))
+	if err != nil {
+		t.Fatalf("decodeTagData(%q) failed: %v", "\xc4\x82\x21\x19\x6a\xb3", err)
+	}
+	if string(got) != "\"273.15\"" {
+		t.Errorf("decodeTagData(0xc48221196ab3)=%s, want: \"273.15\"", got)
+	}
+}
+
+// TestBigfloatHugeExponent checks that a tiny record with a huge bigfloat
+// exponent does not take long to decode.
+func TestBigfloatHugeExponent(t *testing.T) {
+	for _, in := range []string{
+		"\xc5\x82\x1a\x01\x00\x00\x00\x02",
+		"\xc5\x82\x1a\x03\xd7\x43\x01\x02",
+		"\xc5\x82\x3a\x03\xd7\x43\x01\x02",
+	} {
+		start := time.Now()
+		var buf bytes.Buffer
+		if err := Cbor2JsonManyObjects(strings.NewReader(in), &buf); err != nil {
+			t.Errorf("Cbor2JsonManyObjects(0x%s) failed: %v", hex.EncodeToString([]byte(in)), err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Cbor2JsonManyObjects(0x%s) took %v", hex.EncodeToString([]byte(in)), d)
+		}
+	}
+}
+
+func TestDecodeTagWidths(t *testing.T) {
+	var tagTestCases = []struct {
+		json   string
+		binary string
+	}{
+		// Known tags with every argument width.
+		{"\"10.0.0.1\"", "\xd9\x01\x04\x44\x0a\x00\x00\x01"},
+		{"\"10.0.0.1\"", "\xda\x00\x00\x01\x04\x44\x0a\x00\x00\x01"},
+		{"\"10.0.0.1\"", "\xdb\x00\x00\x00\x00\x00\x00\x01\x04\x44\x0a\x00\x00\x01"},
+		{"\"2013-02-04T03:54:00Z\"", "\xd8\x01\x1a\x51\x0f\x30\xd8"},
+		{"18446744073709551616", "\xd8\x02\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+		// Unknown tags.
+		{"{\"@tag\":0,\"@value\":\"2013-03-21T20:04:00Z\"}", "\xc0\x74\x32\x30\x31\x33\x2d\x30\x33\x2d\x32\x31\x54\x32\x30\x3a\x30\x34\x3a\x30\x30\x5a"},
+		{"{\"@tag\":32,\"@value\":\"http://x\"}", "\xd8\x20\x68http://x"},
+		{"{\"@tag\":1000,\"@value\":[1,2]}", "\xd9\x03\xe8\x82\x01\x02"},
+		{"{\"@tag\":65536,\"@value\":null}", "\xda\x00\x01\x00\x00\xf6"},
+		{"{\"@tag\":18446744073709551615,\"@value\":{\"@tag\":7,\"@value\":1}}",
+			"\xdb\xff\xff\xff\xff\xff\xff\xff\xff\xc7\x01"},
+	}
+	DecodeTimeZone = time.UTC
+	for _, tc := range tagTestCases {
+		got, err := decodeTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if string(got) != tc.json {
+			t.Errorf("decodeTagData(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), got, tc.json)
+		}
+	}
+}
+
 var compositeCborTestCases = []struct {
 	binary []byte
 	json   string
@@ -346,6 +621,7 @@ var compositeCborTestCases = []struct {
 	{[]byte("\xbf\x64IETF\x20\x65Array\x9f\x20\x00\x18\xc8\x14\xff\xff"), "{\"IETF\":-1,\"Array\":[-1,0,200,20]}\n"},
 	{[]byte("\xbf\x64IETF\x64YES!\x65Array\x9f\x20\x00\x18\xc8\x14\xff\xff"), "{\"IETF\":\"YES!\",\"Array\":[-1,0,200,20]}\n"},
 	{[]byte("\xbf\x65level\x64info\x67Float32\xfa\x40\x4c\xcc\xcd\xff"), "{\"level\":\"info\",\"Float32\":3.2}\n"},
+	{[]byte("\xa1\x7f\x63lev\x62el\xff\x7f\x64info\xff"), "{\"level\":\"info\"}\n"},
 }
 
 func TestDecodeCbor2Json(t *testing.T) {
@@ -363,23 +639,61 @@ func TestDecodeCbor2Json(t *testing.T) {
 
 var negativeCborTestCases = []struct {
 	binary []byte
-	errStr string
+	record int
+	offset int64
+	path   string
+	major  int
+	errStr string // empty for io.ErrUnexpectedEOF
 }{
-	{[]byte("\xb9\x64IETF\x20\x65Array\x9f\x20\x00\x18\xc8\x14"), "Tried to Read 18 Bytes.. But hit end of file"},
-	{[]byte("\xbf\x64IETF\x20\x65Array\x9f\x20\x00\x18\xc8\x14"), "EOF"},
-	{[]byte("\xbf\x14IETF\x20\x65Array\x9f\x20\x00\x18\xc8\x14"), "Tried to Read 40736 Bytes.. But hit end of file"},
-	{[]byte("\xbf\x64IETF"), "EOF"},
-	{[]byte("\xbf\x64IETF\x20\x65Array\x9f\x20\x00\x18\xc8\xff\xff\xff"), "Invalid Additional Type: 31 in decodeSimpleFloat"},
-	{[]byte("\xbf\x64IETF\x20\x65Array"), "EOF"},
-	{[]byte("\xbf\x64"), "Tried to Read 4 Bytes.. But hit end of file"},
+	{[]byte("\xb9\x64IETF\x20\x65Array\x9f\x20\x00\x18\xc8\x14"), 0, 9, "$.VEYgZUE=", 3, ""},
+	{[]byte("\xbf\x64IETF\x20\x65Array\x9f\x20\x00\x18\xc8\x14"), 0, 13, "$.Array", 4, ""},
+	{[]byte("\xbf\x14IETF\x20\x65Array\x9f\x20\x00\x18\xc8\x14"), 0, 12, "$", 3, ""},
+	{[]byte("\xbf\x64IETF"), 0, 6, "$.IETF", -1, ""},
+	{[]byte("\xbf\x64IETF\x20\x65Array\x9f\x20\x00\x18\xc8\xff\xff\xff"), 1, 20, "$", 7, "Invalid Additional Type: 31 in decodeSimpleFloat"},
+	{[]byte("\xbf\x64IETF\x20\x65Array"), 0, 13, "$.Array", -1, ""},
+	{[]byte("\xbf\x64"), 0, 1, "$", 3, ""},
+	{[]byte("\xa1\x7f\x62ab\x41c\xff\x01"), 0, 5, "$", 2, "Major type is: 64 in string chunk (expected 96)"},
+	{[]byte("\xa1\x7f\x7f\xff\xff\x01"), 0, 2, "$", 3, "Nested indefinite length string chunk"},
+	{[]byte("\xa1\x61a\xa1\x82\x01\x7f\x41c\xff\x01"), 0, 7, "$.a", 2, "Major type is: 64 in string chunk (expected 96)"},
 }
 
 func TestDecodeNegativeCbor2Json(t *testing.T) {
 	for _, tc := range negativeCborTestCases {
 		buf := bytes.NewBuffer([]byte{})
 		err := Cbor2JsonManyObjects(getReader(string(tc.binary)), buf)
-		if err == nil || err.Error() != tc.errStr {
-			t.Errorf("Expected error got:%s, want:%s", err, tc.errStr)
+		de, ok := err.(*DecodeError)
+		if !ok {
+			t.Errorf("Cbor2JsonManyObjects(0x%s) error=%v, want a *DecodeError", hex.EncodeToString(tc.binary), err)
+			continue
+		}
+		if de.Record != tc.record || de.Offset != tc.offset || de.Path != tc.path || de.Major != tc.major {
+			t.Errorf("Cbor2JsonManyObjects(0x%s) error=%v, want record %d, offset %d, at %s, major %d",
+				hex.EncodeToString(tc.binary), err, tc.record, tc.offset, tc.path, tc.major)
+		}
+		if tc.errStr == "" && de.Err != io.ErrUnexpectedEOF || tc.errStr != "" && de.Err.Error() != tc.errStr {
+			t.Errorf("Cbor2JsonManyObjects(0x%s) error=%v, want: %q", hex.EncodeToString(tc.binary), de.Err, tc.errStr)
 		}
 	}
 }
+
+func TestDecoderNext(t *testing.T) {
+	bin := "\xa1\x61a\x01\xa1\x61b\x82\x02"
+	d := NewDecoder(bytes.NewReader([]byte(bin)))
+	m, err := d.Next()
+	if err != nil || m["a"] != int64(1) {
+		t.Fatalf("Next()=%v, %v, want: map[a:1]", m, err)
+	}
+	_, err = d.Next()
+	de, ok := err.(*DecodeError)
+	if !ok || de.Record != 1 || de.Path != "$.b[1]" || de.Err != io.ErrUnexpectedEOF {
+		t.Errorf("Next() error=%v, want unexpected EOF at record 1, $.b[1]", err)
+	}
+
+	d = NewDecoder(bytes.NewReader([]byte("\xa0")))
+	if _, err = d.Next(); err != nil {
+		t.Fatalf("Next() failed: %v", err)
+	}
+	if _, err = d.Next(); err != io.EOF {
+		t.Errorf("Next() at end of stream error=%v, want: io.EOF", err)
+	}
+}
@@ -0,0 +1,291 @@
+package csd
+
+// This file contains code to render CBOR data items in the diagnostic
+// notation of RFC 8949 section 8, with encoding indicators.
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Cbor2DiagManyObjects decodes all the CBOR Objects read from src and
+// writes each to dst in diagnostic notation, followed by a newline.
+// Unlike JSON, diagnostic notation shows how the data is encoded: byte
+// strings (h'..'), tags (1(..)), indefinite lengths ([_ ..], (_ ..)) and
+// arguments or floats that are wider than necessary (_0 to _3).
+//
+// Returns error (if any) that was encountered during decode, the same as
+// Cbor2JsonManyObjects.
+func Cbor2DiagManyObjects(src io.Reader, dst io.Writer, opts DecoderOptions) error {
+	rdr := newCborReader(src, resolveOptions([]DecoderOptions{opts}))
+	var buf []byte
+	for {
+		if _, err := rdr.Peek(1); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		rdr.startRecord()
+		var err error
+		if buf, err = diagOneItem(rdr, buf[:0]); err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+		if _, err := dst.Write(buf); err != nil {
+			return err
+		}
+		rdr.record++
+	}
+}
+
+// diagOneItem appends the next data item in diagnostic notation.
+func diagOneItem(src *cborReader, dst []byte) ([]byte, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
+	minor := h.minor()
+	if major == majorTypeSimpleAndFloat {
+		return diagSimpleFloat(src, h, dst)
+	}
+	if minor == additionalTypeInfiniteCount {
+		switch major {
+		case majorTypeByteString, majorTypeUtf8String:
+			return diagIndefiniteString(src, h, dst)
+		case majorTypeArray, majorTypeMap:
+			return diagContainer(src, h, dst)
+		}
+	}
+	if major == majorTypeArray || major == majorTypeMap {
+		return diagContainer(src, h, dst)
+	}
+	val, err := decodeIntAdditonalType(src, h)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorTypeUnsignedInt, majorTypeNegativeInt:
+		dst = appendInteger(dst, major, val)
+	case majorTypeByteString, majorTypeUtf8String:
+		if err := src.checkLimit(h, "MaxStringLength", val, src.limits.MaxStringLength); err != nil {
+			return nil, err
+		}
+		pb, err := readNBytes(src, h, val)
+		if err != nil {
+			return nil, err
+		}
+		if major == majorTypeByteString {
+			dst = append(dst, "h'"...)
+			for _, v := range pb {
+				dst = append(dst, hexTable[v>>4], hexTable[v&0x0f])
+			}
+			dst = append(dst, '\'')
+		} else {
+			dst = append(dst, '"')
+			dst = decodeStringComplex(dst, string(pb), 0)
+			dst = append(dst, '"')
+		}
+	case majorTypeTags:
+		dst = strconv.AppendUint(dst, val, 10)
+		dst = appendDiagIndicator(dst, minor, val)
+		dst = append(dst, '(')
+		if err := src.enter(h); err != nil {
+			return nil, err
+		}
+		defer src.leave()
+		if dst, err = diagOneItem(src, dst); err != nil {
+			return nil, prependPath(err, "("+strconv.FormatUint(val, 10)+")")
+		}
+		return append(dst, ')'), nil
+	}
+	return appendDiagIndicator(dst, minor, val), nil
+}
+
+// appendDiagIndicator appends the encoding indicator of an argument val
+// encoded with additional information minor, if that is not the shortest
+// encoding.
+func appendDiagIndicator(dst []byte, minor byte, val uint64) []byte {
+	if minor < additionalTypeIntUint8 || minor > additionalTypeIntUint64 {
+		return dst
+	}
+	if appendCborTypePrefix(nil, 0, val)[0] == minor {
+		return dst
+	}
+	return append(dst, '_', '0'+minor-additionalTypeIntUint8)
+}
+
+func diagContainer(src *cborReader, h head, dst []byte) ([]byte, error) {
+	if err := src.enter(h); err != nil {
+		return nil, err
+	}
+	defer src.leave()
+	count, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return nil, err
+	}
+	isMap := h.major() == majorTypeMap
+	if isMap {
+		dst = append(dst, '{')
+	} else {
+		dst = append(dst, '[')
+	}
+	if unSpecifiedCount {
+		dst = append(dst, "_ "...)
+	} else if ind := appendDiagIndicator(nil, h.minor(), uint64(count)); ind != nil {
+		dst = append(append(dst, ind...), ' ')
+	}
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, count, unSpecifiedCount)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+		if i > 0 {
+			dst = append(dst, ", "...)
+		}
+		if !isMap {
+			if dst, err = diagOneItem(src, dst); err != nil {
+				return nil, prependPath(err, indexPath(i))
+			}
+			continue
+		}
+		n := len(dst)
+		if dst, err = diagOneItem(src, dst); err != nil {
+			return nil, err
+		}
+		key := string(dst[n:])
+		dst = append(dst, ": "...)
+		if dst, err = diagOneItem(src, dst); err != nil {
+			return nil, prependPath(err, keyPath([]byte(key)))
+		}
+	}
+	if isMap {
+		return append(dst, '}'), nil
+	}
+	return append(dst, ']'), nil
+}
+
+// diagIndefiniteString appends the chunks of an indefinite length string
+// as (_ chunk, ...).
+func diagIndefiniteString(src *cborReader, h head, dst []byte) ([]byte, error) {
+	start := len(dst)
+	dst = append(dst, "(_ "...)
+	for i := 0; ; i++ {
+		isBreak, err := readBreak(src, h)
+		if err != nil {
+			return nil, err
+		}
+		if isBreak {
+			if i == 0 {
+				// No chunks at all.
+				dst = dst[:start]
+				if h.major() == majorTypeByteString {
+					return append(dst, "''_"...), nil
+				}
+				return append(dst, "\"\"_"...), nil
+			}
+			return append(dst, ')'), nil
+		}
+		ch, err := peekHead(src)
+		if err != nil {
+			return nil, err
+		}
+		if ch.major() != h.major() || ch.minor() == additionalTypeInfiniteCount {
+			src.ReadByte()
+			return nil, src.errorf(ch, "Invalid chunk in indefinite length string")
+		}
+		if i > 0 {
+			dst = append(dst, ", "...)
+		}
+		if dst, err = diagOneItem(src, dst); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func diagSimpleFloat(src *cborReader, h head, dst []byte) ([]byte, error) {
+	minor := h.minor()
+	switch minor {
+	case additionalTypeBoolFalse:
+		return append(dst, "false"...), nil
+	case additionalTypeBoolTrue:
+		return append(dst, "true"...), nil
+	case additionalTypeNull:
+		return append(dst, "null"...), nil
+	case additionalTypeUndefined:
+		return append(dst, "undefined"...), nil
+	case additionalTypeFloat16, additionalTypeFloat32, additionalTypeFloat64:
+		src.UnreadByte()
+		v, _, err := decodeFloat(src)
+		if err != nil {
+			return nil, err
+		}
+		dst = appendDiagFloat(dst, v, minor)
+		if preferredFloatWidth(v) != minor {
+			dst = append(dst, '_', '0'+minor-additionalTypeIntUint8)
+		}
+		return dst, nil
+	case additionalTypeBreak:
+		return nil, src.errorf(h, "Unexpected break")
+	}
+	if minor < additionalTypeBoolFalse {
+		return append(append(dst, "simple("...), strconv.Itoa(int(minor))+")"...), nil
+	}
+	if minor == additionalTypeIntUint8 {
+		pb, err := readNBytes(src, h, 1)
+		if err != nil {
+			return nil, err
+		}
+		return append(append(dst, "simple("...), strconv.Itoa(int(pb[0]))+")"...), nil
+	}
+	return nil, src.errorf(h, "Invalid Additional Type: %d in simple value", minor)
+}
+
+// appendDiagFloat appends v, read from a float of width minor, with the
+// shortest number of digits that reads back as the same value.
+func appendDiagFloat(dst []byte, v float64, minor byte) []byte {
+	switch {
+	case math.IsNaN(v):
+		return append(dst, "NaN"...)
+	case math.IsInf(v, 1):
+		return append(dst, "Infinity"...)
+	case math.IsInf(v, -1):
+		return append(dst, "-Infinity"...)
+	}
+	var s []byte
+	switch minor {
+	case additionalTypeFloat16:
+		p, _ := strconv.ParseFloat(string(appendFloat16(nil, v)), 64)
+		s = strconv.AppendFloat(nil, p, 'g', -1, 64)
+	case additionalTypeFloat32:
+		s = strconv.AppendFloat(nil, v, 'g', -1, 32)
+	default:
+		s = strconv.AppendFloat(nil, v, 'g', -1, 64)
+	}
+	// Diagnostic notation distinguishes floats from integers by the
+	// decimal point.
+	if i := strings.IndexAny(string(s), ".e"); i < 0 {
+		s = append(s, ".0"...)
+	} else if s[i] == 'e' {
+		s = append(s[:i], append([]byte(".0"), s[i:]...)...)
+	}
+	return append(dst, s...)
+}
+
+// preferredFloatWidth returns the additional information of the shortest
+// float encoding that represents v exactly.
+func preferredFloatWidth(v float64) byte {
+	switch {
+	case math.IsNaN(v) || float16ToFloat64(float64ToFloat16(v)) == v:
+		return additionalTypeFloat16
+	case float64(float32(v)) == v:
+		return additionalTypeFloat32
+	}
+	return additionalTypeFloat64
+}
@@ -0,0 +1,75 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var diagTestCases = []struct {
+	binary string
+	diag   string
+}{
+	{"\x00", "0"},
+	{"\x18\x01", "1_0"},
+	{"\x19\x01\x00", "256"},
+	{"\x1a\x00\x00\x01\x00", "256_2"},
+	{"\x3b\xff\xff\xff\xff\xff\xff\xff\xff", "-18446744073709551616"},
+	{"\xf9\x3e\x00", "1.5"},
+	{"\xfa\x3f\xc0\x00\x00", "1.5_2"},
+	{"\xfb\x3f\xf1\x99\x99\x99\x99\x99\x9a", "1.1"},
+	{"\xfb\x7e\x37\xe4\x3c\x88\x00\x75\x9c", "1.0e+300"},
+	{"\xf9\x7c\x00", "Infinity"},
+	{"\xfb\x7f\xf8\x00\x00\x00\x00\x00\x00", "NaN_3"},
+	{"\xf9\x80\x00", "-0.0"},
+	{"\xf4\xf5\xf6\xf7", "false\ntrue\nnull\nundefined"},
+	{"\xf0", "simple(16)"},
+	{"\xf8\xff", "simple(255)"},
+	{"\x43\x01\x02\x03", "h'010203'"},
+	{"\x58\x01\xff", "h'ff'_0"},
+	{"\x62\x22\x0a", "\"\\\"\\n\""},
+	{"\x5f\x42\x01\x02\x41\x03\xff", "(_ h'0102', h'03')"},
+	{"\x7f\xff", "\"\"_"},
+	{"\x5f\xff", "''_"},
+	{"\x83\x01\x82\x02\x03\x80", "[1, [2, 3], []]"},
+	{"\x9f\x01\x9f\xff\xff", "[_ 1, [_ ]]"},
+	{"\x98\x01\x01", "[_0 1]"},
+	{"\xbf\x61a\x01\x61b\xa1\x01\x02\xff", "{_ \"a\": 1, \"b\": {1: 2}}"},
+	{"\xa3\x65level\x64info\x64time\xc1\x1a\x5a\xbf\x8d\x6f\x62ip\xd9\x01\x04\x44\xc0\xa8\x0a\x66",
+		"{\"level\": \"info\", \"time\": 1(1522503023), \"ip\": 260(h'c0a80a66')}"},
+	{"\xd8\x01\x00", "1_0(0)"},
+}
+
+func TestCbor2DiagManyObjects(t *testing.T) {
+	for _, tc := range diagTestCases {
+		var buf bytes.Buffer
+		if err := Cbor2DiagManyObjects(strings.NewReader(tc.binary), &buf, DecoderOptions{}); err != nil {
+			t.Errorf("Cbor2DiagManyObjects(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+			continue
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != tc.diag {
+			t.Errorf("Cbor2DiagManyObjects(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), got, tc.diag)
+		}
+	}
+}
+
+var diagErrorTestCases = []struct {
+	binary string
+	path   string
+}{
+	{"\xa1\x61a\x82\x01", "$.a[1]"},
+	{"\xff", "$"},
+	{"\x5f\x61a\xff", "$"},
+	{"\xc1\x81\x1c", "$(1)[0]"},
+	{"\x1f", "$"},
+}
+
+func TestCbor2DiagErrors(t *testing.T) {
+	for _, tc := range diagErrorTestCases {
+		err := Cbor2DiagManyObjects(strings.NewReader(tc.binary), &bytes.Buffer{}, DecoderOptions{})
+		if de, ok := err.(*DecodeError); !ok || de.Path != tc.path {
+			t.Errorf("Cbor2DiagManyObjects(0x%s) error=%v, want a *DecodeError at %s", hex.EncodeToString([]byte(tc.binary)), err, tc.path)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package csd
+
+// This file contains the parser of diagnostic notation (RFC 8949 section
+// 8 and the extended diagnostic notation of RFC 8610 appendix G), which
+// turns it back into CBOR.
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Diag2Cbor returns the CBOR encoding of the data items written in
+// diagnostic notation in diag, separated by whitespace or commas. It
+// understands:
+//
+// Integers (also 0x, 0o and 0b prefixed - beyond 64 bits as bignums),
+// floats (with NaN, Infinity and -Infinity), "text" strings with JSON
+// escapes, byte strings as h'hex', b64'base64' or 'text', arrays [..],
+// maps {k: v, ..}, tags n(item), false, true, null, undefined and
+// simple(n).
+//
+// Encoding indicators _0 to _3 select the width of arguments (and _1 to
+// _3 the precision of floats) instead of the shortest one; [_ ..], {_ ..}
+// and (_ chunk, ..) are indefinite length items, as is an empty string
+// followed by _. Comments run from # to the end of the line or between
+// slashes.
+func Diag2Cbor(diag []byte) ([]byte, error) {
+	p := &diagParser{s: diag}
+	var dst []byte
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos > 0 && p.peek() == ',' {
+			p.pos++
+			if err := p.skip(); err != nil {
+				return nil, err
+			}
+		}
+		if p.pos >= len(p.s) {
+			return dst, nil
+		}
+		var err error
+		if dst, err = p.item(dst); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type diagParser struct {
+	s   []byte
+	pos int
+}
+
+func (p *diagParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("csd: diagnostic notation offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *diagParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+// skip skips whitespace and comments.
+func (p *diagParser) skip() error {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		case '/':
+			end := strings.IndexByte(string(p.s[p.pos+1:]), '/')
+			if end < 0 {
+				return p.errorf("unterminated comment")
+			}
+			p.pos += end + 2
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// expect consumes c, after optional whitespace.
+func (p *diagParser) expect(c byte) error {
+	if err := p.skip(); err != nil {
+		return err
+	}
+	if p.pos >= len(p.s) {
+		return p.errorf("unexpected end of input, expected %q", c)
+	}
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// word returns the run of letters, digits and ".+-_" at the current
+// position.
+func (p *diagParser) word() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '.' || c == '+' || c == '-' || c == '_') {
+			break
+		}
+		// Only an exponent is followed by a sign.
+		if (c == '+' || c == '-') && p.pos > start && p.s[p.pos-1] != 'e' && p.s[p.pos-1] != 'E' {
+			break
+		}
+		p.pos++
+	}
+	return string(p.s[start:p.pos])
+}
+
+// indicator parses the encoding indicator after an item: -1 if there is
+// none (the shortest encoding), 0-3 for _0 to _3 and -2 for _ (indefinite
+// length).
+func (p *diagParser) indicator() (int, error) {
+	if p.peek() != '_' {
+		return -1, nil
+	}
+	p.pos++
+	c := p.peek()
+	if c >= '0' && c <= '3' {
+		p.pos++
+		return int(c - '0'), nil
+	}
+	if c >= '4' && c <= '9' {
+		return 0, p.errorf("invalid encoding indicator _%c", c)
+	}
+	return -2, nil
+}
+
+// splitIndicator splits the encoding indicator off a number or tag.
+func (p *diagParser) splitIndicator(w string) (string, int, error) {
+	i := strings.IndexByte(w, '_')
+	if i < 0 {
+		return w, -1, nil
+	}
+	if len(w) != i+2 || w[i+1] < '0' || w[i+1] > '3' {
+		return "", 0, p.errorf("invalid encoding indicator in %q", w)
+	}
+	return w[:i], int(w[i+1] - '0'), nil
+}
+
+// appendHead appends a head of major type major and argument val, using
+// the width of encoding indicator ind.
+func (p *diagParser) appendHead(dst []byte, major byte, val uint64, ind int) ([]byte, error) {
+	if ind < 0 {
+		return appendCborTypePrefix(dst, major, val), nil
+	}
+	n := uint(1) << uint(ind)
+	if n < 8 && val>>(8*n) != 0 {
+		return nil, p.errorf("%d does not fit encoding indicator _%d", val, ind)
+	}
+	dst = append(dst, major|(additionalTypeIntUint8+byte(ind)))
+	for i := int(n) - 1; i >= 0; i-- {
+		dst = append(dst, byte(val>>(8*uint(i))))
+	}
+	return dst, nil
+}
+
+// item parses the next data item and appends its encoding.
+func (p *diagParser) item(dst []byte) ([]byte, error) {
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	switch c := p.peek(); {
+	case c == 0:
+		return nil, p.errorf("unexpected end of input")
+	case c == '[' || c == '{':
+		return p.container(dst)
+	case c == '(':
+		return p.indefiniteString(dst)
+	case c == '"' || c == '\'':
+		return p.str(dst, "")
+	}
+	start := p.pos
+	w := p.word()
+	if w == "" {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	switch w {
+	case "false":
+		return AppendBool(dst, false), nil
+	case "true":
+		return AppendBool(dst, true), nil
+	case "null":
+		return AppendNull(dst), nil
+	case "undefined":
+		return AppendUndefined(dst), nil
+	case "h", "b64":
+		if c := p.peek(); c == '\'' {
+			return p.str(dst, w)
+		}
+	case "simple":
+		return p.simple(dst)
+	}
+	if p.peek() == '(' {
+		return p.tag(dst, w)
+	}
+	p.pos = start
+	return p.number(dst, w)
+}
+
+func (p *diagParser) number(dst []byte, w string) ([]byte, error) {
+	p.pos += len(w)
+	num, ind, err := p.splitIndicator(w)
+	if err != nil {
+		return nil, err
+	}
+	lower := strings.ToLower(num)
+	isHex := strings.HasPrefix(lower, "0x") || strings.HasPrefix(lower, "-0x")
+	if strings.HasSuffix(num, "NaN") || strings.HasSuffix(num, "Infinity") ||
+		(!isHex && strings.ContainsAny(lower, ".e")) {
+		return p.float(dst, num, ind)
+	}
+	n, ok := new(big.Int).SetString(num, 0)
+	if !ok {
+		return nil, p.errorf("invalid number %q", num)
+	}
+	major := majorTypeUnsignedInt
+	if n.Sign() < 0 {
+		major = majorTypeNegativeInt
+		n.Neg(n).Sub(n, big.NewInt(1))
+	}
+	if !n.IsUint64() {
+		if ind >= 0 {
+			return nil, p.errorf("encoding indicator on bignum %s", num)
+		}
+		tag := additionalTypeTagPositiveBignum
+		if major == majorTypeNegativeInt {
+			tag = additionalTypeTagNegativeBignum
+		}
+		return AppendBytes(AppendTag(dst, tag), n.Bytes()), nil
+	}
+	return p.appendHead(dst, major, n.Uint64(), ind)
+}
+
+func (p *diagParser) float(dst []byte, num string, ind int) ([]byte, error) {
+	var v float64
+	switch num {
+	case "NaN":
+		v = math.NaN()
+	case "Infinity":
+		v = math.Inf(1)
+	case "-Infinity":
+		v = math.Inf(-1)
+	default:
+		var err error
+		if v, err = strconv.ParseFloat(num, 64); err != nil {
+			return nil, p.errorf("invalid float %q", num)
+		}
+	}
+	width := additionalTypeFloat16 + byte(ind-1)
+	if ind < 0 {
+		width = preferredFloatWidth(v)
+	}
+	switch width {
+	case additionalTypeFloat16:
+		if !math.IsNaN(v) && float16ToFloat64(float64ToFloat16(v)) != v {
+			return nil, p.errorf("%s is not a half precision float", num)
+		}
+		h := float64ToFloat16(v)
+		return append(dst, majorTypeSimpleAndFloat|additionalTypeFloat16, byte(h>>8), byte(h)), nil
+	case additionalTypeFloat32:
+		if !math.IsNaN(v) && float64(float32(v)) != v {
+			return nil, p.errorf("%s is not a single precision float", num)
+		}
+		return AppendFloat32(dst, float32(v)), nil
+	case additionalTypeFloat64:
+		return AppendFloat64(dst, v), nil
+	}
+	return nil, p.errorf("invalid encoding indicator _%d on float", ind)
+}
+
+func (p *diagParser) simple(dst []byte) ([]byte, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	w := p.word()
+	var n uint8
+	if _, err := fmt.Sscan(w, &n); err != nil {
+		return nil, p.errorf("invalid simple value %q", w)
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	if n < additionalTypeIntUint8 {
+		// Including false, true, null and undefined (20-23).
+		return append(dst, majorTypeSimpleAndFloat|n), nil
+	}
+	if n < 32 {
+		return nil, p.errorf("invalid simple value %d", n)
+	}
+	return append(dst, majorTypeSimpleAndFloat|additionalTypeIntUint8, n), nil
+}
+
+func (p *diagParser) tag(dst []byte, w string) ([]byte, error) {
+	num, ind, err := p.splitIndicator(w)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := new(big.Int).SetString(num, 0)
+	if !ok || !n.IsUint64() {
+		return nil, p.errorf("invalid tag number %q", num)
+	}
+	if dst, err = p.appendHead(dst, majorTypeTags, n.Uint64(), ind); err != nil {
+		return nil, err
+	}
+	p.pos++
+	if dst, err = p.item(dst); err != nil {
+		return nil, err
+	}
+	return dst, p.expect(')')
+}
+
+// str parses a string - "text", 'bytes', h'hex' or b64'base64' as given
+// by prefix - with its encoding indicator.
+func (p *diagParser) str(dst []byte, prefix string) ([]byte, error) {
+	start := p.pos
+	q := p.s[p.pos]
+	end := p.pos + 1
+	for ; end < len(p.s) && p.s[end] != q; end++ {
+		if p.s[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(p.s) {
+		return nil, p.errorf("unterminated string")
+	}
+	p.pos = end + 1
+	raw := string(p.s[start+1 : end])
+	var b []byte
+	major := majorTypeByteString
+	switch {
+	case q == '"':
+		var s string
+		if err := json.Unmarshal(p.s[start:end+1], &s); err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid string: %v", err)
+		}
+		b, major = []byte(s), majorTypeUtf8String
+	case prefix == "h":
+		var err error
+		if b, err = hex.DecodeString(strings.Join(strings.Fields(raw), "")); err != nil {
+			return nil, p.errorf("invalid hex string: %v", err)
+		}
+	case prefix == "b64":
+		s := strings.TrimRight(strings.Join(strings.Fields(raw), ""), "=")
+		var err error
+		if strings.ContainsAny(s, "-_") {
+			b, err = base64.RawURLEncoding.DecodeString(s)
+		} else {
+			b, err = base64.RawStdEncoding.DecodeString(s)
+		}
+		if err != nil {
+			return nil, p.errorf("invalid base64 string: %v", err)
+		}
+	default:
+		b = []byte(strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(raw))
+	}
+	ind, err := p.indicator()
+	if err != nil {
+		return nil, err
+	}
+	if ind == -2 {
+		if len(b) != 0 {
+			return nil, p.errorf("only empty strings can be written with _")
+		}
+		return append(dst, major|additionalTypeInfiniteCount, majorTypeSimpleAndFloat|additionalTypeBreak), nil
+	}
+	if dst, err = p.appendHead(dst, major, uint64(len(b)), ind); err != nil {
+		return nil, err
+	}
+	return append(dst, b...), nil
+}
+
+// indefiniteString parses (_ chunk, ...).
+func (p *diagParser) indefiniteString(dst []byte) ([]byte, error) {
+	p.pos++
+	if err := p.expect('_'); err != nil {
+		return nil, err
+	}
+	head := len(dst)
+	dst = append(dst, 0)
+	for i := 0; ; i++ {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.peek() == ')' && i > 0 {
+			p.pos++
+			return append(dst, majorTypeSimpleAndFloat|additionalTypeBreak), nil
+		}
+		if i > 0 {
+			if err := p.expect(','); err != nil {
+				return nil, err
+			}
+		}
+		chunk := len(dst)
+		var err error
+		if dst, err = p.item(dst); err != nil {
+			return nil, err
+		}
+		major := dst[chunk] & maskOutAdditionalType
+		if (major != majorTypeByteString && major != majorTypeUtf8String) ||
+			dst[chunk]&maskOutMajorType == additionalTypeInfiniteCount || (i > 0 && major != dst[head]&maskOutAdditionalType) {
+			return nil, p.errorf("chunks of an indefinite length string must be definite length strings of the same type")
+		}
+		dst[head] = major | additionalTypeInfiniteCount
+	}
+}
+
+// container parses an array or map.
+func (p *diagParser) container(dst []byte) ([]byte, error) {
+	open := p.s[p.pos]
+	p.pos++
+	major, closing := majorTypeArray, byte(']')
+	if open == '{' {
+		major, closing = majorTypeMap, '}'
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	ind, err := p.indicator()
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	n := 0
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.peek() == closing {
+			p.pos++
+			break
+		}
+		if n > 0 {
+			if err := p.expect(','); err != nil {
+				return nil, err
+			}
+		}
+		if body, err = p.item(body); err != nil {
+			return nil, err
+		}
+		if major == majorTypeMap {
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			if body, err = p.item(body); err != nil {
+				return nil, err
+			}
+		}
+		n++
+	}
+	if ind == -2 {
+		dst = append(dst, major|additionalTypeInfiniteCount)
+		return append(append(dst, body...), majorTypeSimpleAndFloat|additionalTypeBreak), nil
+	}
+	if dst, err = p.appendHead(dst, major, uint64(n), ind); err != nil {
+		return nil, err
+	}
+	return append(dst, body...), nil
+}
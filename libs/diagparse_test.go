@@ -0,0 +1,73 @@
+package csd
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+var diag2CborTestCases = []struct {
+	diag   string
+	binary string
+}{
+	{"0x1f 0o17 0b11 -0x10", "\x18\x1f\x0f\x03\x2f"},
+	{"18446744073709551616", "\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+	{"-18446744073709551617", "\xc3\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+	{"1e3 -1.5e-1_3 -Infinity", "\xf9\x63\xd0\xfb\xbf\xc3\x33\x33\x33\x33\x33\x33\xf9\xfc\x00"},
+	{"b64'AQID' b64'-_8' 'it\\'s'", "\x43\x01\x02\x03\x42\xfb\xff\x44it's"},
+	{"h'01 02\n03'_1", "\x59\x00\x03\x01\x02\x03"},
+	{"[1, # comment\n /* comment */ 2]", "\x82\x01\x02"},
+	{"{_1 \"a\": [_ ]}", "\xb9\x00\x01\x61a\x9f\xff"},
+	{"(_ \"a\", \"b\"_0)", "\x7f\x61a\x78\x01b\xff"},
+	{"1, 2,3", "\x01\x02\x03"},
+	{"0_3(null)", "\xdb\x00\x00\x00\x00\x00\x00\x00\x00\xf6"},
+	{"simple(19) simple(20) simple(21) simple(22) simple(23) simple(32)", "\xf3\xf4\xf5\xf6\xf7\xf8\x20"},
+	{"", ""},
+}
+
+func TestDiag2Cbor(t *testing.T) {
+	for _, tc := range diagTestCases {
+		got, err := Diag2Cbor([]byte(tc.diag))
+		if err != nil {
+			t.Errorf("Diag2Cbor(%s) failed: %v", tc.diag, err)
+			continue
+		}
+		if string(got) != tc.binary {
+			t.Errorf("Diag2Cbor(%s)=0x%s, want: 0x%s", tc.diag, hex.EncodeToString(got), hex.EncodeToString([]byte(tc.binary)))
+		}
+	}
+	for _, tc := range diag2CborTestCases {
+		got, err := Diag2Cbor([]byte(tc.diag))
+		if err != nil {
+			t.Errorf("Diag2Cbor(%s) failed: %v", tc.diag, err)
+			continue
+		}
+		if string(got) != tc.binary {
+			t.Errorf("Diag2Cbor(%s)=0x%s, want: 0x%s", tc.diag, hex.EncodeToString(got), hex.EncodeToString([]byte(tc.binary)))
+		}
+	}
+}
+
+func TestDiag2CborErrors(t *testing.T) {
+	for _, diag := range []string{
+		"[1, 2",
+		"{1 2}",
+		"256_0",
+		"1.1_1",
+		"1_4",
+		"h'0'",
+		"\"abc",
+		"(_ h'01', \"a\")",
+		"(_ 1)",
+		"'a'_",
+		"simple(24)",
+		"simple(31)",
+		"foo",
+		"/ comment",
+		"1(2",
+		")",
+	} {
+		if got, err := Diag2Cbor([]byte(diag)); err == nil {
+			t.Errorf("Diag2Cbor(%s)=0x%s, want an error", diag, hex.EncodeToString(got))
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package csd
+
+// This file contains code to encode Go values of any type, and JSON text,
+// as CBOR.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Marshaler is implemented by types that encode themselves as CBOR.
+// MarshalCBOR must return exactly one complete data item.
+type Marshaler interface {
+	MarshalCBOR() ([]byte, error)
+}
+
+// EncoderOptions configures an Encoder. The zero value encodes strings
+// as text strings.
+type EncoderOptions struct {
+	// TimeStringsAsTags encodes strings holding an RFC 3339 time as
+	// timestamps (tag 1).
+	TimeStringsAsTags bool
+	// NetworkStringsAsTags encodes strings holding an IP or MAC address
+	// as network addresses (tag 260) and strings holding a CIDR prefix as
+	// network prefixes (tag 261).
+	NetworkStringsAsTags bool
+}
+
+// Encoder writes CBOR data items to an output stream.
+type Encoder struct {
+	w    io.Writer
+	opts EncoderOptions
+	buf  []byte
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	tagType         = reflect.TypeOf(Tag{})
+	bigIntPtrType   = reflect.TypeOf((*big.Int)(nil))
+	bigFloatPtrType = reflect.TypeOf((*big.Float)(nil))
+)
+
+// NewEncoder returns an Encoder writing to w. If opts are given, the
+// first of them configures the encoder.
+func NewEncoder(w io.Writer, opts ...EncoderOptions) *Encoder {
+	e := &Encoder{w: w}
+	if len(opts) > 0 {
+		e.opts = opts[0]
+	}
+	return e
+}
+
+// Marshal returns the CBOR encoding of v:
+//
+// Structs are encoded as maps keyed by the field names of the `cbor`
+// struct tag, the `json` tag or the field name, as Unmarshal matches them;
+// fields tagged omitempty are left out when empty. Maps are encoded with
+// their keys sorted by their encoding, so the output is deterministic.
+// Nil pointers, interfaces, maps and slices are encoded as null, []byte
+// and byte arrays as byte strings.
+//
+// time.Time is encoded as a timestamp (tag 1), net.IP and
+// net.HardwareAddr as network addresses (tag 260), net.IPNet as a network
+// prefix (tag 261), big.Int as an integer or bignum, Decimal as a decimal
+// fraction, big.Float as a bigfloat, json.RawMessage as embedded JSON
+// (tag 262) and Tag as the tag with its content. Types implementing
+// Marshaler encode themselves.
+func Marshal(v interface{}) ([]byte, error) {
+	return (&Encoder{}).appendValue(nil, reflect.ValueOf(v))
+}
+
+// Encode writes the CBOR encoding of v to the stream. See Marshal for the
+// details.
+func (e *Encoder) Encode(v interface{}) error {
+	b, err := e.appendValue(e.buf[:0], reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	e.buf = b
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *Encoder) appendValue(dst []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return AppendNull(dst), nil
+	}
+	t := v.Type()
+	if t.Implements(marshalerType) && !(t.Kind() == reflect.Ptr && v.IsNil()) {
+		return appendMarshaler(dst, v.Interface().(Marshaler))
+	}
+	if t.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(t).Implements(marshalerType) {
+		return appendMarshaler(dst, v.Addr().Interface().(Marshaler))
+	}
+	switch t {
+	case timeType:
+		return AppendTime(dst, v.Interface().(time.Time)), nil
+	case ipType:
+		if v.IsNil() {
+			return AppendNull(dst), nil
+		}
+		return AppendIP(dst, v.Interface().(net.IP)), nil
+	case hardwareAddrTyp:
+		if v.IsNil() {
+			return AppendNull(dst), nil
+		}
+		return AppendMAC(dst, v.Interface().(net.HardwareAddr)), nil
+	case ipNetType:
+		return AppendIPNet(dst, v.Interface().(net.IPNet)), nil
+	case bigIntType:
+		n := v.Interface().(big.Int)
+		return AppendBigInt(dst, &n), nil
+	case bigIntPtrType:
+		if v.IsNil() {
+			return AppendNull(dst), nil
+		}
+		return AppendBigInt(dst, v.Interface().(*big.Int)), nil
+	case bigFloatType:
+		f := v.Interface().(big.Float)
+		return AppendBigFloat(dst, &f)
+	case bigFloatPtrType:
+		if v.IsNil() {
+			return AppendNull(dst), nil
+		}
+		return AppendBigFloat(dst, v.Interface().(*big.Float))
+	case decimalType:
+		return AppendDecimal(dst, v.Interface().(Decimal)), nil
+	case rawMessageType:
+		if v.IsNil() {
+			return AppendNull(dst), nil
+		}
+		return AppendEmbeddedJSON(dst, v.Bytes()), nil
+	case tagType:
+		tag := v.Interface().(Tag)
+		return e.appendValue(AppendTag(dst, tag.Number), reflect.ValueOf(tag.Content))
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return AppendNull(dst), nil
+		}
+		return e.appendValue(dst, v.Elem())
+	case reflect.Bool:
+		return AppendBool(dst, v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return AppendInt(dst, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return AppendUint(dst, v.Uint()), nil
+	case reflect.Float32:
+		return AppendFloat32(dst, float32(v.Float())), nil
+	case reflect.Float64:
+		return AppendFloat64(dst, v.Float()), nil
+	case reflect.String:
+		return e.appendString(dst, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return AppendNull(dst), nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return AppendBytes(dst, v.Bytes()), nil
+		}
+		return e.appendArray(dst, v)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return AppendBytes(dst, b), nil
+		}
+		return e.appendArray(dst, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return AppendNull(dst), nil
+		}
+		return e.appendMap(dst, v)
+	case reflect.Struct:
+		return e.appendStruct(dst, v)
+	}
+	return nil, fmt.Errorf("csd: unsupported type %s", t)
+}
+
+func appendMarshaler(dst []byte, m Marshaler) ([]byte, error) {
+	b, err := m.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, b...), nil
+}
+
+func (e *Encoder) appendArray(dst []byte, v reflect.Value) ([]byte, error) {
+	dst = AppendArrayHeader(dst, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		var err error
+		if dst, err = e.appendValue(dst, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func (e *Encoder) appendMap(dst []byte, v reflect.Value) ([]byte, error) {
+	type pair struct{ k, v []byte }
+	pairs := make([]pair, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		kb, err := e.appendValue(nil, k)
+		if err != nil {
+			return nil, err
+		}
+		vb, err := e.appendValue(nil, v.MapIndex(k))
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair{kb, vb})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].k, pairs[j].k) < 0 })
+	dst = AppendMapHeader(dst, len(pairs))
+	for _, p := range pairs {
+		dst = append(append(dst, p.k...), p.v...)
+	}
+	return dst, nil
+}
+
+func (e *Encoder) appendStruct(dst []byte, v reflect.Value) ([]byte, error) {
+	var body []byte
+	n := 0
+	for _, f := range cachedFields(v.Type()) {
+		fv := fieldByIndex(v, f.index)
+		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		body = AppendString(body, f.name)
+		var err error
+		if body, err = e.appendValue(body, fv); err != nil {
+			return nil, err
+		}
+		n++
+	}
+	return append(AppendMapHeader(dst, n), body...), nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// appendString appends s, as a tag if the options ask for its content to
+// be recognized.
+func (e *Encoder) appendString(dst []byte, s string) []byte {
+	if e.opts.TimeStringsAsTags {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return AppendTime(dst, t)
+		}
+	}
+	if e.opts.NetworkStringsAsTags {
+		if ip := net.ParseIP(s); ip != nil {
+			return AppendIP(dst, ip)
+		}
+		if _, pfx, err := net.ParseCIDR(s); err == nil {
+			return AppendIPNet(dst, *pfx)
+		}
+		// Only 6 byte (EUI-48) addresses decode as MAC addresses.
+		if mac, err := net.ParseMAC(s); err == nil && len(mac) == 6 {
+			return AppendMAC(dst, mac)
+		}
+	}
+	return AppendString(dst, s)
+}
+
+// Json2CborManyObjects reads a stream of JSON values (such as newline
+// delimited JSON) from src and writes each as a CBOR data item to dst.
+// Object keys keep their order; integers are encoded as integers (or
+// bignums) and other numbers as floats.
+func Json2CborManyObjects(src io.Reader, dst io.Writer, opts EncoderOptions) error {
+	dec := json.NewDecoder(src)
+	dec.UseNumber()
+	e := &Encoder{opts: opts}
+	w := bufio.NewWriter(dst)
+	var buf []byte
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if buf, err = e.appendJSON(buf[:0], dec, tok); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// appendJSON appends the JSON value starting with tok, reading the rest
+// of it from dec.
+func (e *Encoder) appendJSON(dst []byte, dec *json.Decoder, tok json.Token) ([]byte, error) {
+	switch v := tok.(type) {
+	case json.Delim:
+		var body []byte
+		n := 0
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if v == '{' {
+				// Keys are always strings; they are not recognized as tags.
+				body = AppendString(body, tok.(string))
+				if tok, err = dec.Token(); err != nil {
+					return nil, err
+				}
+			}
+			if body, err = e.appendJSON(body, dec, tok); err != nil {
+				return nil, err
+			}
+			n++
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		if v == '{' {
+			dst = AppendMapHeader(dst, n)
+		} else {
+			dst = AppendArrayHeader(dst, n)
+		}
+		return append(dst, body...), nil
+	case json.Number:
+		return appendJSONNumber(dst, v)
+	case string:
+		return e.appendString(dst, v), nil
+	case bool:
+		return AppendBool(dst, v), nil
+	case nil:
+		return AppendNull(dst), nil
+	}
+	return nil, fmt.Errorf("csd: unexpected JSON token %v", tok)
+}
+
+func appendJSONNumber(dst []byte, n json.Number) ([]byte, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return AppendInt(dst, i), nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return AppendUint(dst, u), nil
+	}
+	if b, ok := new(big.Int).SetString(string(n), 10); ok {
+		return AppendBigInt(dst, b), nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, err
+	}
+	return AppendFloat64(dst, f), nil
+}
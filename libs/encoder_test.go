@@ -0,0 +1,170 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (id hexID) MarshalCBOR() ([]byte, error) {
+	b, err := hex.DecodeString(string(id))
+	if err != nil {
+		return nil, err
+	}
+	return AppendBytes(nil, b), nil
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	_, pfx, _ := net.ParseCIDR("192.168.0.0/16")
+	in := logEntry{
+		logCtx:  logCtx{Host: "h1", Port: 8080},
+		Level:   "info",
+		Time:    time.Unix(1522495887, 0).UTC(),
+		Message: "TCA:",
+		Fault:   -41650,
+		IP:      net.IP{10, 0, 0, 1},
+		MAC:     net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0xab},
+		Prefix:  pfx,
+		Tags:    []string{"a", "b"},
+		Extra:   map[string]interface{}{"n": int64(1), "m": nil},
+		Any:     []interface{}{int64(1), true, 1.5},
+		Skipped: "not encoded",
+	}
+	bin, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal(%+v) failed: %v", in, err)
+	}
+	var out logEntry
+	if err := Unmarshal(bin, &out); err != nil {
+		t.Fatalf("Unmarshal(0x%s) failed: %v", hex.EncodeToString(bin), err)
+	}
+	in.Skipped = ""
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal(Marshal(%+v))=%+v", in, out)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	n := 42
+	var marshalTestCases = []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, "\xf6"},
+		{&n, "\x18\x2a"},
+		{(*int)(nil), "\xf6"},
+		{[]byte{1, 2}, "\x42\x01\x02"},
+		{[2]byte{1, 2}, "\x42\x01\x02"},
+		{[]int(nil), "\xf6"},
+		{[]int8{-1}, "\x81\x20"},
+		{float32(1.5), "\xfa\x3f\xc0\x00\x00"},
+		{map[int]string{10: "b", 1: "a", -1: "c"}, "\xa3\x01\x61a\x0a\x61b\x20\x61c"},
+		{map[string]int{"bb": 2, "a": 1}, "\xa2\x61a\x01\x62bb\x02"},
+		{struct {
+			A int    `cbor:"a,omitempty"`
+			B string `json:"b,omitempty"`
+			C bool
+		}{}, "\xa1\x61C\xf4"},
+		{logCtx{Host: "h"}, "\xa1\x64host\x61h"},
+		{hexID("beef"), "\x42\xbe\xef"},
+		{[]hexID{"01"}, "\x81\x41\x01"},
+		{big.NewInt(-1), "\x20"},
+		{Tag{Number: 100, Content: "x"}, "\xd8\x64\x61x"},
+		{json.RawMessage(`{}`), "\xd9\x01\x06\x42{}"},
+	}
+	for _, tc := range marshalTestCases {
+		got, err := Marshal(tc.v)
+		if err != nil {
+			t.Errorf("Marshal(%#v) failed: %v", tc.v, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("Marshal(%#v)=0x%s, want: 0x%s", tc.v, hex.EncodeToString(got), hex.EncodeToString([]byte(tc.want)))
+		}
+	}
+	if _, err := Marshal(make(chan int)); err == nil {
+		t.Errorf("Marshal(chan) succeeded, want error")
+	}
+	if _, err := Marshal(hexID("xyz")); err == nil {
+		t.Errorf("Marshal of a failing Marshaler succeeded, want error")
+	}
+}
+
+func TestEncoderEncode(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, EncoderOptions{TimeStringsAsTags: true})
+	for _, v := range []interface{}{"2018-03-31T11:31:27Z", map[string]int{"a": 1}} {
+		if err := e.Encode(v); err != nil {
+			t.Fatalf("Encode(%v) failed: %v", v, err)
+		}
+	}
+	want := "\xc1\x1a\x5a\xbf\x71\x8f" + "\xa1\x61a\x01"
+	if buf.String() != want {
+		t.Errorf("Encode()=0x%s, want: 0x%s", hex.EncodeToString(buf.Bytes()), hex.EncodeToString([]byte(want)))
+	}
+	e = NewEncoder(errWriter{})
+	if err := e.Encode(1); err == nil {
+		t.Errorf("Encode() to a failing writer succeeded, want error")
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+var json2CborTestCases = []struct {
+	json   string
+	opts   EncoderOptions
+	binary string
+}{
+	{`{"b":1,"a":[true,null,-2.5,"x"]}`, EncoderOptions{},
+		"\xa2\x61b\x01\x61a\x84\xf5\xf6\xfb\xc0\x04\x00\x00\x00\x00\x00\x00\x61x"},
+	{`18446744073709551615 -18446744073709551616 18446744073709551616`, EncoderOptions{},
+		"\x1b\xff\xff\xff\xff\xff\xff\xff\xff" + "\x3b\xff\xff\xff\xff\xff\xff\xff\xff" + "\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+	{`{"ts":"2018-03-31T11:31:27Z","ip":"10.0.0.1"}`, EncoderOptions{TimeStringsAsTags: true},
+		"\xa2\x62ts\xc1\x1a\x5a\xbf\x71\x8f\x62ip\x6810.0.0.1"},
+	{`["10.0.0.1","192.168.0.0/16","12:34:56:78:90:ab","10.0.0.1x"]`, EncoderOptions{NetworkStringsAsTags: true},
+		"\x84\xd9\x01\x04\x44\x0a\x00\x00\x01\xd9\x01\x05\xa1\x44\xc0\xa8\x00\x00\x10" +
+			"\xd9\x01\x04\x46\x12\x34\x56\x78\x90\xab\x6910.0.0.1x"},
+}
+
+func TestJson2CborManyObjects(t *testing.T) {
+	for _, tc := range json2CborTestCases {
+		var buf bytes.Buffer
+		if err := Json2CborManyObjects(strings.NewReader(tc.json), &buf, tc.opts); err != nil {
+			t.Errorf("Json2CborManyObjects(%s) failed: %v", tc.json, err)
+			continue
+		}
+		if buf.String() != tc.binary {
+			t.Errorf("Json2CborManyObjects(%s)=0x%s, want: 0x%s", tc.json, hex.EncodeToString(buf.Bytes()), hex.EncodeToString([]byte(tc.binary)))
+		}
+	}
+	if err := Json2CborManyObjects(strings.NewReader(`{"a":`), &bytes.Buffer{}, EncoderOptions{}); err == nil {
+		t.Errorf("Json2CborManyObjects of truncated JSON succeeded, want error")
+	}
+}
+
+func TestJson2CborRoundTrip(t *testing.T) {
+	ndjson := `{"level":"info","time":"2018-03-31T11:31:27Z","ip":"10.0.0.1","n":[1,-1,1.5],"m":{"k":null}}` + "\n" +
+		`{"level":"warn","mac":"12:34:56:78:90:ab","pfx":"10.0.0.0/8"}` + "\n"
+	var bin, out bytes.Buffer
+	opts := EncoderOptions{TimeStringsAsTags: true, NetworkStringsAsTags: true}
+	if err := Json2CborManyObjects(strings.NewReader(ndjson), &bin, opts); err != nil {
+		t.Fatalf("Json2CborManyObjects(%s) failed: %v", ndjson, err)
+	}
+	if err := Cbor2JsonManyObjectsOptions(&bin, &out, DecoderOptions{TimeZone: time.UTC}); err != nil {
+		t.Fatalf("Cbor2JsonManyObjectsOptions() failed: %v", err)
+	}
+	if out.String() != ndjson {
+		t.Errorf("round trip of %s=%s", ndjson, out.String())
+	}
+}
@@ -0,0 +1,112 @@
+package csd
+
+// This file contains the error type returned when decoding fails.
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// DecodeError describes a failure to decode the CBOR stream.
+//
+// Err is io.ErrUnexpectedEOF when the input ended in the middle of a
+// record; callers following a growing file can use that to distinguish a
+// truncated (possibly still being written) record from corrupted data.
+type DecodeError struct {
+	// Offset is the byte offset in the stream of the data item that
+	// could not be decoded.
+	Offset int64
+	// Record is the index (starting at 0) of the top level object.
+	Record int
+	// Major and Minor are the major type (0-7) and additional information
+	// of the data item, or -1 if its initial byte could not be read.
+	Major int
+	Minor int
+	// Path locates the data item within the record, e.g. $.ctx.ips[2]
+	Path string
+	// Err is the underlying reason.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	s := "csd: record " + strconv.Itoa(e.Record) + ", offset " + strconv.FormatInt(e.Offset, 10) + ", at " + e.Path
+	if e.Major >= 0 {
+		s += " (major type " + strconv.Itoa(e.Major) + ", minor " + strconv.Itoa(e.Minor) + ")"
+	}
+	return s + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// head is the initial byte of a data item along with its stream offset.
+type head struct {
+	off int64
+	b   byte
+}
+
+func (h head) major() byte {
+	return h.b & maskOutAdditionalType
+}
+
+func (h head) minor() byte {
+	return h.b & maskOutMajorType
+}
+
+// errorf returns a DecodeError for the data item h.
+func (r *cborReader) errorf(h head, format string, args ...interface{}) error {
+	return r.wrapError(h, fmt.Errorf(format, args...))
+}
+
+// wrapError returns err as a DecodeError for the data item h. Errors that
+// already are DecodeErrors are returned unchanged.
+func (r *cborReader) wrapError(h head, err error) error {
+	if _, ok := err.(*DecodeError); ok {
+		return err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &DecodeError{
+		Offset: h.off,
+		Record: r.record,
+		Major:  int(h.major() >> majorOffset),
+		Minor:  int(h.minor()),
+		Path:   "$",
+		Err:    err,
+	}
+}
+
+// eofError returns the error for input ending where a data item should
+// have started.
+func (r *cborReader) eofError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &DecodeError{Offset: r.off, Record: r.record, Major: -1, Minor: -1, Path: "$", Err: err}
+}
+
+// prependPath adds a path element (".key" or "[index]") in front of the
+// path of a DecodeError as it propagates out of a container.
+func prependPath(err error, elem string) error {
+	if de, ok := err.(*DecodeError); ok {
+		de.Path = "$" + elem + de.Path[1:]
+	}
+	return err
+}
+
+// keyError locates err, a failure to decode a map key, at the map itself:
+// a path into a complex key would read as the path of a value.
+func keyError(err error) error {
+	if de, ok := err.(*DecodeError); ok {
+		de.Path = "$"
+	}
+	return err
+}
+
+func indexPath(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
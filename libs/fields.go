@@ -0,0 +1,164 @@
+package csd
+
+// This file contains the mapping of struct fields to CBOR map keys, shared
+// by Unmarshal and Marshal.
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// field is a struct field encoded as a map entry.
+type field struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedFields returns the fields of struct type t, honoring `cbor` struct
+// tags and falling back to `json` tags.
+func cachedFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t, nil, []reflect.Type{t}))
+	return f.([]field)
+}
+
+// typeFields returns the fields of struct type t found at index. outer holds
+// t and the structs it is embedded in.
+func typeFields(t reflect.Type, index []int, outer []reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("cbor")
+		if !ok {
+			tag = sf.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		idx := append(append([]int{}, index...), i)
+		if ft := embeddedStruct(sf); ft != nil && name == "" {
+			// Fields of embedded structs (and pointers to them) are
+			// promoted - once, a struct may embed a pointer to itself.
+			if !containsType(outer, ft) {
+				fields = append(fields, typeFields(ft, idx, append(outer, ft))...)
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		f := field{name: name, index: idx, typ: sf.Type}
+		for _, o := range strings.Split(opts, ",") {
+			if o == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	if index != nil {
+		return fields
+	}
+	// Fields of embedded structs are hidden by fields of the same name at
+	// a shallower depth.
+	var visible []field
+	for i, f := range fields {
+		hidden := false
+		for j, g := range fields {
+			if i != j && g.name == f.name && len(g.index) < len(f.index) {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			visible = append(visible, f)
+		}
+	}
+	return visible
+}
+
+// fieldByIndex returns the field of struct v at index, or the zero Value if
+// it is in an embedded struct behind a nil pointer.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// fieldByIndexAlloc returns the field of struct v at index, allocating the
+// embedded structs behind nil pointers on the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// embeddedStruct returns the struct type of sf if it is an embedded struct
+// or pointer to struct. Pointers to unexported structs are not, they could
+// not be allocated.
+func embeddedStruct(sf reflect.StructField) reflect.Type {
+	if !sf.Anonymous {
+		return nil
+	}
+	switch t := sf.Type; {
+	case t.Kind() == reflect.Struct:
+		return t
+	case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && sf.PkgPath == "":
+		return t.Elem()
+	}
+	return nil
+}
+
+// containsType reports whether t is one of types.
+func containsType(types []reflect.Type, t reflect.Type) bool {
+	for _, u := range types {
+		if u == t {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupField returns the field called name, preferring an exact match
+// over a case insensitive one.
+func lookupField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
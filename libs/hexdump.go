@@ -0,0 +1,318 @@
+package csd
+
+// This file contains the annotated hexdump of a CBOR stream, showing the
+// encoding of every data item and where decoding breaks.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// hexdumpColumn is the width of the offset, indentation and bytes of a
+// hexdump line, before the explanation.
+const hexdumpColumn = 40
+
+// hexdumpPayloadWidth is the number of payload bytes per hexdump line.
+const hexdumpPayloadWidth = 16
+
+var tagNames = map[uint64]string{
+	additionalTypeTimestamp:            "epoch time",
+	additionalTypeTagPositiveBignum:    "positive bignum",
+	additionalTypeTagNegativeBignum:    "negative bignum",
+	additionalTypeTagDecimalFraction:   "decimal fraction",
+	additionalTypeTagBigfloat:          "bigfloat",
+	additionalTypeTagExpectedBase64URL: "expected base64url",
+	additionalTypeTagExpectedBase64:    "expected base64",
+	additionalTypeTagExpectedBase16:    "expected hex",
+	additionalTypeTagNetworkAddr:       "network address",
+	additionalTypeTagNetworkPrefix:     "network prefix",
+	additionalTypeEmbeddedJSON:         "embedded JSON",
+	additionalTypeTagHexString:         "hex string",
+}
+
+type hexdumper struct {
+	src *cborReader
+	dst io.Writer
+	// printed is the offset of the first byte not shown yet.
+	printed int64
+	// key is the text string or integer just shown, for the paths of
+	// errors in maps.
+	key string
+	err error
+}
+
+// line writes the bytes b at offset off, indented by depth, followed by
+// the explanation.
+func (d *hexdumper) line(off int64, b []byte, depth int, explanation string) {
+	s := fmt.Sprintf("%08x  %s", off, strings.Repeat("  ", depth))
+	for _, v := range b {
+		s += string([]byte{hexTable[v>>4], hexTable[v&0x0f]})
+	}
+	if explanation != "" {
+		if pad := hexdumpColumn - len(s); pad > 0 {
+			s += strings.Repeat(" ", pad)
+		}
+		s += " # " + explanation
+	}
+	if _, err := io.WriteString(d.dst, s+"\n"); err != nil && d.err == nil {
+		d.err = err
+	}
+	if end := off + int64(len(b)); end > d.printed {
+		d.printed = end
+	}
+}
+
+// payload writes the bytes of a string starting at offset off, a line of
+// hexdumpPayloadWidth bytes at a time.
+func (d *hexdumper) payload(off int64, b []byte, depth int, explanation string) {
+	if len(b) == 0 {
+		return
+	}
+	for i := 0; i < len(b); i += hexdumpPayloadWidth {
+		end := i + hexdumpPayloadWidth
+		if end > len(b) {
+			end = len(b)
+		}
+		d.line(off+int64(i), b[i:end], depth, explanation)
+		explanation = ""
+	}
+}
+
+// item writes the next data item, and everything nested in it, at depth.
+func (d *hexdumper) item(depth int) error {
+	src := d.src
+	h, err := readHead(src)
+	if err != nil {
+		return err
+	}
+	major := h.major()
+	minor := h.minor()
+	if minor == additionalTypeInfiniteCount {
+		switch major {
+		case majorTypeByteString, majorTypeUtf8String, majorTypeArray, majorTypeMap:
+			return d.indefinite(h, depth)
+		}
+		if major == majorTypeSimpleAndFloat {
+			return src.errorf(h, "Unexpected break")
+		}
+	}
+	val, raw, err := readRawArgument(src, h, []byte{h.b})
+	if err != nil {
+		return err
+	}
+	switch major {
+	case majorTypeUnsignedInt, majorTypeNegativeInt:
+		d.key = string(appendInteger(nil, major, val))
+		what := "unsigned("
+		if major == majorTypeNegativeInt {
+			what = "negative("
+		}
+		d.line(h.off, raw, depth, what+d.key+")")
+	case majorTypeByteString, majorTypeUtf8String:
+		if err := src.checkLimit(h, "MaxStringLength", val, src.limits.MaxStringLength); err != nil {
+			return err
+		}
+		what, explanation := "bytes", ""
+		if major == majorTypeUtf8String {
+			what = "text"
+		}
+		d.line(h.off, raw, depth, fmt.Sprintf("%s(%d)", what, val))
+		off := src.off
+		pb, err := readNBytes(src, h, val)
+		if err != nil {
+			return err
+		}
+		if major == majorTypeUtf8String {
+			d.key = string(pb)
+			explanation = "\"" + string(decodeStringComplex(nil, string(pb), 0)) + "\""
+		}
+		d.payload(off, pb, depth+1, explanation)
+	case majorTypeArray, majorTypeMap:
+		if err := src.checkLimit(h, "MaxElements", val, src.limits.MaxElements); err != nil {
+			return err
+		}
+		if err := src.enter(h); err != nil {
+			return err
+		}
+		defer src.leave()
+		n := val
+		what := "array"
+		if major == majorTypeMap {
+			n *= 2
+			what = "map"
+		}
+		d.line(h.off, raw, depth, fmt.Sprintf("%s(%d)", what, val))
+		key := ""
+		for i := uint64(0); i < n; i++ {
+			d.key = ""
+			if err := d.item(depth + 1); err != nil {
+				return hexdumpPath(err, major, int(i), key)
+			}
+			key = d.key
+		}
+	case majorTypeTags:
+		if err := src.enter(h); err != nil {
+			return err
+		}
+		defer src.leave()
+		explanation := "tag(" + strconv.FormatUint(val, 10) + ")"
+		if name, ok := tagNames[val]; ok {
+			explanation += " " + name
+		}
+		d.line(h.off, raw, depth, explanation)
+		return d.item(depth + 1)
+	case majorTypeSimpleAndFloat:
+		d.line(h.off, raw, depth, simpleExplanation(minor, val))
+	}
+	return nil
+}
+
+// indefinite writes the indefinite length item h and its elements or
+// chunks, up to and including the break code.
+func (d *hexdumper) indefinite(h head, depth int) error {
+	src := d.src
+	what := map[byte]string{majorTypeByteString: "bytes", majorTypeUtf8String: "text",
+		majorTypeArray: "array", majorTypeMap: "map"}[h.major()]
+	if h.major() == majorTypeArray || h.major() == majorTypeMap {
+		if err := src.enter(h); err != nil {
+			return err
+		}
+		defer src.leave()
+	}
+	d.line(h.off, []byte{h.b}, depth, what+"(*)")
+	key := ""
+	for i := 0; ; i++ {
+		off := src.off
+		isBreak, err := readBreak(src, h)
+		if err != nil {
+			return err
+		}
+		if isBreak {
+			d.line(off, []byte{majorTypeSimpleAndFloat | additionalTypeBreak}, depth+1, "break")
+			return nil
+		}
+		switch h.major() {
+		case majorTypeByteString, majorTypeUtf8String:
+			ch, err := peekHead(src)
+			if err != nil {
+				return err
+			}
+			if ch.major() != h.major() || ch.minor() == additionalTypeInfiniteCount {
+				src.ReadByte()
+				return src.errorf(ch, "Invalid chunk in indefinite length string")
+			}
+		case majorTypeArray:
+			if err := src.checkLimit(h, "MaxElements", uint64(i)+1, src.limits.MaxElements); err != nil {
+				return err
+			}
+		default:
+			if i%2 == 0 {
+				if err := src.checkLimit(h, "MaxElements", uint64(i/2)+1, src.limits.MaxElements); err != nil {
+					return err
+				}
+			}
+		}
+		d.key = ""
+		if err := d.item(depth + 1); err != nil {
+			return hexdumpPath(err, h.major(), i, key)
+		}
+		key = d.key
+	}
+}
+
+// path adds the path of the i-th item of an array or map (counting keys
+// and values) to err. key is the preceding text string or integer key.
+func hexdumpPath(err error, major byte, i int, key string) error {
+	switch {
+	case major == majorTypeArray:
+		return prependPath(err, indexPath(i))
+	case major == majorTypeMap && i%2 == 1:
+		return prependPath(err, "."+key)
+	}
+	return err
+}
+
+// simpleExplanation describes the simple value or float of major type 7
+// with additional information minor and argument val.
+func simpleExplanation(minor byte, val uint64) string {
+	switch minor {
+	case additionalTypeBoolFalse:
+		return "false"
+	case additionalTypeBoolTrue:
+		return "true"
+	case additionalTypeNull:
+		return "null"
+	case additionalTypeUndefined:
+		return "undefined"
+	case additionalTypeFloat16:
+		return "float16(" + string(appendDiagFloat(nil, float16ToFloat64(uint16(val)), minor)) + ")"
+	case additionalTypeFloat32:
+		return "float32(" + string(appendDiagFloat(nil, float64(math.Float32frombits(uint32(val))), minor)) + ")"
+	case additionalTypeFloat64:
+		return "float64(" + string(appendDiagFloat(nil, math.Float64frombits(val), minor)) + ")"
+	}
+	return "simple(" + strconv.FormatUint(val, 10) + ")"
+}
+
+// Cbor2HexdumpManyObjects writes an annotated hexdump of the CBOR stream
+// read from src to dst: every data item on its own line, with its offset,
+// the bytes of its head (indented by nesting depth) and an explanation,
+// followed by the payload of strings.
+//
+// Decoding does not stop at malformed data. The error is written in place,
+// followed by the bytes skipped up to the next top level map that decodes
+// cleanly, and the dump continues from there. The first such error is
+// returned once the whole input has been dumped, along with any error
+// returned by src (other than io.EOF) or dst.
+func Cbor2HexdumpManyObjects(src io.Reader, dst io.Writer, opts DecoderOptions) error {
+	r := &replayReader{src: src}
+	d := &hexdumper{src: newCborReader(r, resolveOptions([]DecoderOptions{opts})), dst: dst}
+	rdr := d.src
+	var firstErr error
+	for d.err == nil {
+		start := rdr.off
+		if _, err := rdr.Peek(1); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		rdr.startRecord()
+		err := d.item(0)
+		rdr.record++
+		if err == nil {
+			r.discard(rdr.off)
+			continue
+		}
+		if r.err != nil && r.err != io.EOF {
+			return r.err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		fmt.Fprintf(dst, "%08x  ## %v\n", d.printed, err)
+		// Skip what was not shown yet - at least the first byte of the
+		// record, so that it is not decoded again.
+		skippedAt, from := d.printed, d.printed
+		var skipped []byte
+		if from <= start {
+			b, _ := r.byteAt(start)
+			skipped = append(skipped, b)
+			from = start + 1
+		}
+		_, rerr := resync(r, rdr, from, func(off int64, b byte) {
+			skipped = append(skipped, b)
+		})
+		d.payload(skippedAt, skipped, 0, fmt.Sprintf("skipped %d bytes", len(skipped)))
+		if rerr != nil {
+			return rerr
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return firstErr
+}
@@ -0,0 +1,72 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var hexdumpTestCases = []struct {
+	binary string
+	dump   string
+	failed bool
+}{
+	{"\xa2\x61a\x18\x01\x61b\x9f\x20\xf9\x3e\x00\xff",
+		`00000000  a2                             # map(2)
+00000001    61                           # text(1)
+00000002      61                         # "a"
+00000003    1801                         # unsigned(1)
+00000005    61                           # text(1)
+00000006      62                         # "b"
+00000007    9f                           # array(*)
+00000008      20                         # negative(-1)
+00000009      f93e00                     # float16(1.5)
+0000000c      ff                         # break
+`, false},
+	{"\xc1\x1a\x5a\xbf\x71\x8f\x5f\x41\x01\xff\x51\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10",
+		`00000000  c1                             # tag(1) epoch time
+00000001    1a5abf718f                   # unsigned(1522495887)
+00000006  5f                             # bytes(*)
+00000007    41                           # bytes(1)
+00000008      01
+00000009    ff                           # break
+0000000a  51                             # bytes(17)
+0000000b    000102030405060708090a0b0c0d0e0f
+0000001b    10
+`, false},
+	{"\xa1\x61x\x1c\x00\x00\xa1\x61y\xf6",
+		`00000000  a1                             # map(1)
+00000001    61                           # text(1)
+00000002      78                         # "x"
+00000003  ## csd: record 0, offset 3, at $.x (major type 0, minor 28): Invalid Additional Type: 28 in data item
+00000003  1c0000                         # skipped 3 bytes
+00000006  a1                             # map(1)
+00000007    61                           # text(1)
+00000008      79                         # "y"
+00000009    f6                           # null
+`, true},
+	{"\xff\xa1\x61y\xf5\x62",
+		`00000000  ## csd: record 0, offset 0, at $ (major type 7, minor 31): Unexpected break
+00000000  ff                             # skipped 1 bytes
+00000001  a1                             # map(1)
+00000002    61                           # text(1)
+00000003      79                         # "y"
+00000004    f5                           # true
+00000005  62                             # text(2)
+00000006  ## csd: record 2, offset 5, at $ (major type 3, minor 2): unexpected EOF
+`, true},
+}
+
+func TestCbor2HexdumpManyObjects(t *testing.T) {
+	for _, tc := range hexdumpTestCases {
+		var buf bytes.Buffer
+		err := Cbor2HexdumpManyObjects(strings.NewReader(tc.binary), &buf, DecoderOptions{})
+		if _, ok := err.(*DecodeError); ok != tc.failed || (err != nil && !ok) {
+			t.Errorf("Cbor2HexdumpManyObjects(0x%s) error=%v, want a *DecodeError: %v", hex.EncodeToString([]byte(tc.binary)), err, tc.failed)
+		}
+		if buf.String() != tc.dump {
+			t.Errorf("Cbor2HexdumpManyObjects(0x%s)=\n%s\nwant:\n%s", hex.EncodeToString([]byte(tc.binary)), buf.String(), tc.dump)
+		}
+	}
+}
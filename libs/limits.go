@@ -0,0 +1,81 @@
+package csd
+
+// This file contains the limits protecting the decoder from malicious or
+// corrupted input.
+
+import "fmt"
+
+// Limits bounds the resources spent decoding a single record. A zero
+// field means no limit.
+type Limits struct {
+	// MaxDepth is the maximum nesting depth of arrays, maps and tags.
+	MaxDepth int
+	// MaxStringLength is the maximum length in bytes of a byte or text
+	// string (all chunks of an indefinite length string together).
+	MaxStringLength int64
+	// MaxElements is the maximum number of elements of an array (or
+	// pairs of a map).
+	MaxElements int64
+	// MaxRecordSize is the maximum encoded size in bytes of a top level
+	// object.
+	MaxRecordSize int64
+}
+
+// DecodeLimits - the limits enforced when decoding, unless overridden by
+// DecoderOptions.Limits. The defaults are well beyond what any log record
+// needs.
+var DecodeLimits = Limits{
+	MaxDepth:        256,
+	MaxStringLength: 16 << 20,
+	MaxElements:     1 << 20,
+	MaxRecordSize:   64 << 20,
+}
+
+// LimitError is the Err of a DecodeError when the input exceeds one of
+// the decode Limits.
+type LimitError struct {
+	// Limit is the name of the exceeded Limits field, e.g. "MaxDepth".
+	Limit string
+	// Value is the (claimed) size of the input, Max the limit.
+	Value uint64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s exceeded: %d (limit %d)", e.Limit, e.Value, e.Max)
+}
+
+// checkLimit returns a LimitError for the data item h if value exceeds
+// max (which is unlimited if 0).
+func (r *cborReader) checkLimit(h head, limit string, value uint64, max int64) error {
+	if max > 0 && value > uint64(max) {
+		return r.wrapError(h, &LimitError{Limit: limit, Value: value, Max: max})
+	}
+	return nil
+}
+
+// checkRecordSize checks if reading n more bytes of the data item h keeps
+// the current record within MaxRecordSize.
+func (r *cborReader) checkRecordSize(h head, n uint64) error {
+	return r.checkLimit(h, "MaxRecordSize", uint64(r.off-r.recordStart)+n, r.limits.MaxRecordSize)
+}
+
+// enter is called when decoding descends into the array, map or tag h.
+// Every successful enter is paired with a leave.
+func (r *cborReader) enter(h head) error {
+	if err := r.checkLimit(h, "MaxDepth", uint64(r.depth+1), int64(r.limits.MaxDepth)); err != nil {
+		return err
+	}
+	r.depth++
+	return nil
+}
+
+func (r *cborReader) leave() {
+	r.depth--
+}
+
+// startRecord is called before decoding each top level object.
+func (r *cborReader) startRecord() {
+	r.recordStart = r.off
+	r.depth = 0
+}
@@ -0,0 +1,66 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// exceededLimit returns the name of the limit exceeded according to err.
+func exceededLimit(err error) string {
+	if de, ok := err.(*DecodeError); ok {
+		if le, ok := de.Err.(*LimitError); ok {
+			return le.Limit
+		}
+	}
+	return ""
+}
+
+func TestDecodeLimits(t *testing.T) {
+	saved := DecodeLimits
+	defer func() { DecodeLimits = saved }()
+	DecodeLimits = Limits{MaxDepth: 3, MaxStringLength: 8, MaxElements: 4, MaxRecordSize: 32}
+
+	var limitTestCases = []struct {
+		binary string
+		limit  string
+	}{
+		{"\xa1\x61a" + "\x81\x81\x81\x01", "MaxDepth"},
+		{"\xa1\x61a" + "\xc1\xc1\xc1\x01", "MaxDepth"},
+		{"\xa1\x61a\x81\x9f\x9f\x01\xff\xff", "MaxDepth"},
+		// A 9 byte header claiming a huge string.
+		{"\xa1\x61a\x5b\x7f\xff\xff\xff\xff\xff\xff\xff", "MaxStringLength"},
+		{"\xa1\x61a\x69abcdefghi", "MaxStringLength"},
+		{"\xa1\x61a\x7f\x65abcde\x64fghi\xff", "MaxStringLength"},
+		{"\xa1\x61a\x9b\xff\xff\xff\xff\xff\xff\xff\xff", "MaxElements"},
+		{"\xa1\x61a\x9f\x01\x02\x03\x04\x05\xff", "MaxElements"},
+		{"\xbf\x61a\x01\x61b\x02\x61c\x03\x61d\x04\x61e\x05\xff", "MaxElements"},
+		{"\xa1\x61a\x84\x68abcdefgh\x68abcdefgh\x68abcdefgh\x68abcdefgh", "MaxRecordSize"},
+	}
+	for _, tc := range limitTestCases {
+		err := Cbor2JsonManyObjects(strings.NewReader(tc.binary), bytes.NewBuffer([]byte{}))
+		if exceededLimit(err) != tc.limit {
+			t.Errorf("Cbor2JsonManyObjects(0x%s) error=%v, want %s exceeded", hex.EncodeToString([]byte(tc.binary)), err, tc.limit)
+		}
+		_, err = NewDecoder(strings.NewReader(tc.binary)).Next()
+		if exceededLimit(err) != tc.limit {
+			t.Errorf("Next(0x%s) error=%v, want %s exceeded", hex.EncodeToString([]byte(tc.binary)), err, tc.limit)
+		}
+	}
+
+	// Limits apply per record, and exactly at the limit is fine.
+	bin := "\xa1\x61a\x83\x81\x65abcde\x02\x03" + "\xa1\x61a\x83\x81\x65abcde\x02\x03"
+	buf := bytes.NewBuffer([]byte{})
+	err := Cbor2JsonManyObjects(strings.NewReader(bin), buf)
+	if err != nil || buf.String() != "{\"a\":[[\"abcde\"],2,3]}\n{\"a\":[[\"abcde\"],2,3]}\n" {
+		t.Errorf("Cbor2JsonManyObjects(0x%s)=%s (err: %v)", hex.EncodeToString([]byte(bin)), buf.String(), err)
+	}
+
+	// Zero means no limit.
+	DecodeLimits = Limits{}
+	bin = "\xa1\x61a" + strings.Repeat("\x81", 300) + "\x01"
+	if err := Cbor2JsonManyObjects(strings.NewReader(bin), bytes.NewBuffer([]byte{})); err != nil {
+		t.Errorf("Cbor2JsonManyObjects(0x%s) failed without limits: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+}
@@ -0,0 +1,103 @@
+package csd
+
+// This file contains the options of a single decoder. The package level
+// variables (DecodeTimeZone, IntegerTimeFieldFormat, ...) only provide the
+// defaults.
+
+import "time"
+
+// DecoderOptions configures a Decoder, Cbor2JsonManyObjectsOptions or a
+// follow reader. Zero fields take their value from the package level
+// defaults at the time the options are used.
+type DecoderOptions struct {
+	// TimeZone timestamps are rendered in (default DecodeTimeZone, or
+	// UTC if that is not set either).
+	TimeZone *time.Location
+	// IntegerTimeFormat and NanoTimeFormat are the formats of timestamps
+	// with integer and float seconds (default IntegerTimeFieldFormat and
+	// NanoTimeFieldFormat).
+	IntegerTimeFormat string
+	NanoTimeFormat    string
+	// BigNumbers is how bignums, decimal fractions and bigfloats are
+	// rendered (default DecodeBigNumbersAsString).
+	BigNumbers BigNumberFormat
+	// ByteEncoding of byte strings, unless overridden by one of the
+	// expected conversion tags (21, 22, 23) (default ByteEncodingBase64).
+	ByteEncoding ByteEncoding
+	// ComplexKeysAsPairs renders maps with array or map keys as an array
+	// of [key,value] pairs instead of an object whose keys hold the JSON
+	// text of the array or map.
+	ComplexKeysAsPairs bool
+	// EmbeddedJSONAsRaw makes the Decoder return embedded JSON (tag 262)
+	// as json.RawMessage instead of the parsed value.
+	EmbeddedJSONAsRaw bool
+	// Limits enforced when decoding (default DecodeLimits). Use &Limits{}
+	// to decode without any limits.
+	Limits *Limits
+	// Tags holds tag handlers used instead of the ones registered with
+	// RegisterTag. A handler with both functions nil disables decoding of
+	// the tag, which is then treated as an unknown tag.
+	Tags map[uint64]TagHandler
+	// FollowPollInterval is how often a follow reader checks the file for
+	// new data (default FileFollowPollInterval). Where the file can be
+	// watched for changes (inotify on Linux), new data is read as soon as
+	// it is written and polling is only a fallback for changes that are
+	// not reported, as on network file systems.
+	FollowPollInterval time.Duration
+	// FollowByName makes a follow reader follow the file name rather than
+	// the open file, as tail -F: when the file is renamed or removed
+	// (rotated) it reads the old one to the end and reopens the name, and
+	// when it is truncated it starts over from offset 0.
+	FollowByName bool
+	// OnFollowReopen, if set, is called when a follow reader following by
+	// name reopens the file or starts over, with the reason - "rotated" or
+	// "truncated".
+	OnFollowReopen func(name string, reason string)
+}
+
+// resolveOptions returns the first of opts (if any) with its zero fields
+// set to the package level defaults.
+func resolveOptions(opts []DecoderOptions) *DecoderOptions {
+	o := DecoderOptions{}
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.TimeZone == nil {
+		o.TimeZone = DecodeTimeZone
+		if o.TimeZone == nil {
+			o.TimeZone = time.UTC
+		}
+	}
+	if o.IntegerTimeFormat == "" {
+		o.IntegerTimeFormat = IntegerTimeFieldFormat
+	}
+	if o.NanoTimeFormat == "" {
+		o.NanoTimeFormat = NanoTimeFieldFormat
+	}
+	if o.BigNumbers == BigNumbersDefault {
+		o.BigNumbers = BigNumbersAsNumber
+		if DecodeBigNumbersAsString {
+			o.BigNumbers = BigNumbersAsString
+		}
+	}
+	if o.ByteEncoding == ByteEncodingDefault {
+		o.ByteEncoding = ByteEncodingBase64
+	}
+	if o.Limits == nil {
+		limits := DecodeLimits
+		o.Limits = &limits
+	}
+	if o.FollowPollInterval == 0 {
+		o.FollowPollInterval = FileFollowPollInterval
+	}
+	return &o
+}
+
+// tagHandler returns the handler of tag number, looking at the decoder's
+// own handlers before the registered ones.
+func (o *DecoderOptions) tagHandler(number uint64) (TagHandler, bool) {
+	if th, ok := o.Tags[number]; ok {
+		return th, th.Render != nil || th.Unmarshal != nil
+	}
+	return lookupTag(number)
+}
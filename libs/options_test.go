@@ -0,0 +1,117 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDecoderOptions(t *testing.T) {
+	bin := "\xa1\x61t\xc1\x1a\x5a\xbf\x71\x8f"
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	ist := time.FixedZone("IST", 5*3600+1800)
+
+	var optionTestCases = []struct {
+		opts DecoderOptions
+		json string
+	}{
+		{DecoderOptions{}, "{\"t\":\"2018-03-31T11:31:27Z\"}\n"},
+		{DecoderOptions{TimeZone: la}, "{\"t\":\"2018-03-31T04:31:27-07:00\"}\n"},
+		{DecoderOptions{TimeZone: ist}, "{\"t\":\"2018-03-31T17:01:27+05:30\"}\n"},
+		{DecoderOptions{IntegerTimeFormat: time.Kitchen}, "{\"t\":\"11:31AM\"}\n"},
+	}
+	// Decoders with different options must not interfere with each other.
+	var wg sync.WaitGroup
+	for _, tc := range optionTestCases {
+		wg.Add(1)
+		go func(opts DecoderOptions, want string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				buf := bytes.NewBuffer([]byte{})
+				err := Cbor2JsonManyObjectsOptions(strings.NewReader(bin), buf, opts)
+				if err != nil || buf.String() != want {
+					t.Errorf("Cbor2JsonManyObjectsOptions(0x%s)=%s (err: %v), want: %s", hex.EncodeToString([]byte(bin)), buf.String(), err, want)
+					return
+				}
+			}
+		}(tc.opts, tc.json)
+	}
+	wg.Wait()
+}
+
+// TestDecoderOptionsOverrideGlobals checks that options left zero take
+// the package level default, and set ones override it.
+func TestDecoderOptionsOverrideGlobals(t *testing.T) {
+	DecodeBigNumbersAsString = true
+	defer func() { DecodeBigNumbersAsString = false }()
+	bin := "\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"
+	for _, tc := range []struct {
+		opts []DecoderOptions
+		json string
+	}{
+		{nil, "\"18446744073709551616\"\n"},
+		{[]DecoderOptions{{TimeZone: time.UTC}}, "\"18446744073709551616\"\n"},
+		{[]DecoderOptions{{BigNumbers: BigNumbersAsNumber}}, "18446744073709551616\n"},
+	} {
+		buf := bytes.NewBuffer([]byte{})
+		var err error
+		if tc.opts == nil {
+			err = Cbor2JsonManyObjects(strings.NewReader(bin), buf)
+		} else {
+			err = Cbor2JsonManyObjectsOptions(strings.NewReader(bin), buf, tc.opts[0])
+		}
+		if err != nil || buf.String() != tc.json {
+			t.Errorf("Cbor2JsonManyObjects(0x%s, %v)=%s (err: %v), want: %s", hex.EncodeToString([]byte(bin)), tc.opts, buf.String(), err, tc.json)
+		}
+	}
+}
+
+func TestDecoderOptionsTags(t *testing.T) {
+	opts := DecoderOptions{Tags: map[uint64]TagHandler{
+		additionalTypeTimestamp: {
+			Render: func(content interface{}, opts *DecoderOptions) ([]byte, error) {
+				return []byte("\"epoch\""), nil
+			},
+			Unmarshal: func(content interface{}, opts *DecoderOptions) (interface{}, error) {
+				return "epoch", nil
+			},
+		},
+		additionalTypeTagHexString: {},
+	}}
+	bin := "\xa2\x61t\xc1\x01\x61h\xd9\x01\x07\x41\xab"
+	buf := bytes.NewBuffer([]byte{})
+	err := Cbor2JsonManyObjectsOptions(strings.NewReader(bin), buf, opts)
+	want := "{\"t\":\"epoch\",\"h\":{\"@tag\":263,\"@value\":\"qw==\"}}\n"
+	if err != nil || buf.String() != want {
+		t.Errorf("Cbor2JsonManyObjectsOptions(0x%s)=%s (err: %v), want: %s", hex.EncodeToString([]byte(bin)), buf.String(), err, want)
+	}
+	m, err := NewDecoder(strings.NewReader(bin), opts).Next()
+	if err != nil {
+		t.Fatalf("Next(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	if tag, ok := m["h"].(Tag); m["t"] != "epoch" || !ok || tag.Number != 263 {
+		t.Errorf("Next(0x%s)=%v, want: map[h:{263 [171]} t:epoch]", hex.EncodeToString([]byte(bin)), m)
+	}
+
+	// Other decoders still use the registered handlers.
+	buf.Reset()
+	err = Cbor2JsonManyObjects(strings.NewReader(bin), buf)
+	want = "{\"t\":\"1970-01-01T00:00:01Z\",\"h\":\"ab\"}\n"
+	if err != nil || buf.String() != want {
+		t.Errorf("Cbor2JsonManyObjects(0x%s)=%s (err: %v), want: %s", hex.EncodeToString([]byte(bin)), buf.String(), err, want)
+	}
+}
+
+func TestDecoderOptionsLimits(t *testing.T) {
+	bin := "\xa1\x61a\x81\x81\x01"
+	_, err := NewDecoder(strings.NewReader(bin), DecoderOptions{Limits: &Limits{MaxDepth: 2}}).Next()
+	if exceededLimit(err) != "MaxDepth" {
+		t.Errorf("Next(0x%s) error=%v, want MaxDepth exceeded", hex.EncodeToString([]byte(bin)), err)
+	}
+	if _, err = NewDecoder(strings.NewReader(bin)).Next(); err != nil {
+		t.Errorf("Next(0x%s) failed with default limits: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+}
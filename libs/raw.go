@@ -0,0 +1,132 @@
+package csd
+
+// This file contains code to read a complete data item without decoding
+// it.
+
+// readRawItem reads the next data item (with everything nested in it)
+// and appends its encoded bytes to dst. If dst is nil, the item is only
+// skipped.
+func readRawItem(src *cborReader, dst []byte) ([]byte, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	if dst != nil {
+		dst = append(dst, h.b)
+	}
+	major := h.major()
+	minor := h.minor()
+	if minor == additionalTypeInfiniteCount {
+		switch major {
+		case majorTypeByteString, majorTypeUtf8String, majorTypeArray, majorTypeMap:
+			return readRawItems(src, h, dst)
+		}
+		return nil, src.errorf(h, "Unexpected indefinite length or break in data item")
+	}
+	val, dst, err := readRawArgument(src, h, dst)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorTypeByteString, majorTypeUtf8String:
+		if err := src.checkLimit(h, "MaxStringLength", val, src.limits.MaxStringLength); err != nil {
+			return nil, err
+		}
+		pb, err := readNBytes(src, h, val)
+		if err != nil {
+			return nil, err
+		}
+		if dst != nil {
+			dst = append(dst, pb...)
+		}
+	case majorTypeArray, majorTypeMap:
+		if err := src.checkLimit(h, "MaxElements", val, src.limits.MaxElements); err != nil {
+			return nil, err
+		}
+		if err := src.enter(h); err != nil {
+			return nil, err
+		}
+		defer src.leave()
+		n := val
+		if major == majorTypeMap {
+			n *= 2
+		}
+		for i := uint64(0); i < n; i++ {
+			if dst, err = readRawItem(src, dst); err != nil {
+				return nil, err
+			}
+		}
+	case majorTypeTags:
+		if err := src.enter(h); err != nil {
+			return nil, err
+		}
+		defer src.leave()
+		return readRawItem(src, dst)
+	}
+	return dst, nil
+}
+
+// readRawItems reads the items of the indefinite length item h up to and
+// including the break code.
+func readRawItems(src *cborReader, h head, dst []byte) ([]byte, error) {
+	if h.major() == majorTypeArray || h.major() == majorTypeMap {
+		if err := src.enter(h); err != nil {
+			return nil, err
+		}
+		defer src.leave()
+	}
+	for i := 0; ; i++ {
+		isBreak, err := readBreak(src, h)
+		if err != nil {
+			return nil, err
+		}
+		if isBreak {
+			if dst != nil {
+				dst = append(dst, majorTypeSimpleAndFloat|additionalTypeBreak)
+			}
+			return dst, nil
+		}
+		if h.major() == majorTypeByteString || h.major() == majorTypeUtf8String {
+			pb, err := src.Peek(1)
+			if err == nil && (pb[0]&maskOutAdditionalType != h.major() || pb[0]&maskOutMajorType == additionalTypeInfiniteCount) {
+				ch, _ := readHead(src)
+				return nil, src.errorf(ch, "Invalid chunk in indefinite length string")
+			}
+		} else if h.major() == majorTypeArray {
+			if err := src.checkLimit(h, "MaxElements", uint64(i)+1, src.limits.MaxElements); err != nil {
+				return nil, err
+			}
+		} else if i%2 == 0 {
+			if err := src.checkLimit(h, "MaxElements", uint64(i/2)+1, src.limits.MaxElements); err != nil {
+				return nil, err
+			}
+		}
+		if dst, err = readRawItem(src, dst); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readRawArgument reads the argument (count, length, value or tag number)
+// following the initial byte of h.
+func readRawArgument(src *cborReader, h head, dst []byte) (uint64, []byte, error) {
+	minor := h.minor()
+	if minor <= additionalMax {
+		return uint64(minor), dst, nil
+	}
+	if minor > additionalTypeIntUint64 {
+		return 0, nil, src.errorf(h, "Invalid Additional Type: %d in data item", minor)
+	}
+	pb, err := readNBytes(src, h, 1<<(minor-additionalTypeIntUint8))
+	if err != nil {
+		return 0, nil, err
+	}
+	val := uint64(0)
+	for _, b := range pb {
+		val = val<<8 | uint64(b)
+	}
+	if dst != nil {
+		dst = append(dst, pb...)
+	}
+	return val, dst, nil
+}
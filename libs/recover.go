@@ -0,0 +1,167 @@
+package csd
+
+// This file contains the recovery mode of the JSON converter, which skips
+// over corrupted parts of the stream instead of giving up on them.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// SkippedRange is a part of the input skipped in recovery mode.
+type SkippedRange struct {
+	// Start is the offset of the first skipped byte, End the offset of
+	// the first byte after the skipped range.
+	Start, End int64
+	// Err is the error that made the decoder skip the range.
+	Err error
+}
+
+func (s SkippedRange) String() string {
+	return fmt.Sprintf("bytes %d-%d (%d bytes): %v", s.Start, s.End, s.End-s.Start, s.Err)
+}
+
+// replayReader remembers the bytes read from src since offset base so the
+// decoder can rewind to any of them and decode from there again.
+type replayReader struct {
+	src  io.Reader
+	err  error
+	buf  []byte
+	base int64
+	pos  int
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	if r.pos < len(r.buf) {
+		n := copy(p, r.buf[r.pos:])
+		r.pos += n
+		return n, nil
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.src.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	r.pos = len(r.buf)
+	r.err = err
+	return n, err
+}
+
+// byteAt returns the byte at stream offset off, reading ahead if needed.
+func (r *replayReader) byteAt(off int64) (byte, error) {
+	i := int(off - r.base)
+	for i >= len(r.buf) {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var chunk [4096]byte
+		n, err := r.src.Read(chunk[:])
+		r.buf = append(r.buf, chunk[:n]...)
+		r.err = err
+	}
+	return r.buf[i], nil
+}
+
+// discard forgets the bytes before offset off - the decoder won't rewind
+// before it anymore.
+func (r *replayReader) discard(off int64) {
+	n := int(off - r.base)
+	r.buf = r.buf[n:]
+	r.pos -= n
+	r.base = off
+}
+
+// seek makes src continue decoding at offset off.
+func (r *replayReader) seek(src *cborReader, off int64) {
+	r.pos = int(off - r.base)
+	src.Reader.Reset(r)
+	src.off = off
+	src.startRecord()
+}
+
+// plausibleRecordStart reports if b can start a non-empty top level map.
+func plausibleRecordStart(b byte) bool {
+	return b&maskOutAdditionalType == majorTypeMap && b != majorTypeMap &&
+		(b&maskOutMajorType <= additionalTypeIntUint64 || b&maskOutMajorType == additionalTypeInfiniteCount)
+}
+
+// resync looks for the first offset at or after from where a non-empty
+// map decodes cleanly and positions src there. If there is no such map,
+// src is positioned at the end of the input. skip (if not nil) is called
+// with every byte skipped over.
+func resync(r *replayReader, src *cborReader, from int64, skip func(off int64, b byte)) (int64, error) {
+	var out bytes.Buffer
+	for off := from; ; off++ {
+		r.discard(off)
+		b, err := r.byteAt(off)
+		if err == io.EOF {
+			r.seek(src, off)
+			return off, nil
+		}
+		if err != nil {
+			return off, err
+		}
+		if plausibleRecordStart(b) {
+			r.seek(src, off)
+			out.Reset()
+			if cbor2JsonOneObject(src, &out) == nil && out.Len() > len("{}") {
+				r.seek(src, off)
+				return off, nil
+			}
+			if r.err != nil && r.err != io.EOF {
+				return off, r.err
+			}
+		}
+		if skip != nil {
+			skip(off, b)
+		}
+	}
+}
+
+// Cbor2JsonManyObjectsRecover is like Cbor2JsonManyObjects, except that
+// it does not stop at malformed records. The corrupted part of the input
+// is skipped up to the next top level map that decodes cleanly, and
+// decoding resumes from there. Only complete records are written to dst.
+//
+// report (if not nil) is called for every skipped range as soon as it is
+// known. All skipped ranges are returned, along with the error returned
+// by src (other than io.EOF), if any. If opts are given, the first of
+// them configures decoding.
+func Cbor2JsonManyObjectsRecover(src io.Reader, dst io.Writer, report func(SkippedRange), opts ...DecoderOptions) ([]SkippedRange, error) {
+	r := &replayReader{src: src}
+	rdr := newCborReader(r, resolveOptions(opts))
+	var skipped []SkippedRange
+	var out bytes.Buffer
+	for {
+		start := rdr.off
+		if _, err := rdr.Peek(1); err != nil {
+			if err == io.EOF {
+				return skipped, nil
+			}
+			return skipped, err
+		}
+		out.Reset()
+		rdr.startRecord()
+		err := cbor2JsonOneObject(rdr, &out)
+		rdr.record++
+		if err == nil {
+			out.WriteByte('\n')
+			dst.Write(out.Bytes())
+			r.discard(rdr.off)
+			continue
+		}
+		if r.err != nil && r.err != io.EOF {
+			return skipped, r.err
+		}
+		end, rerr := resync(r, rdr, start+1, nil)
+		s := SkippedRange{Start: start, End: end, Err: err}
+		skipped = append(skipped, s)
+		if report != nil {
+			report(s)
+		}
+		if rerr != nil {
+			return skipped, rerr
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var recoverTestCases = []struct {
+	binary  string
+	json    string
+	skipped []SkippedRange
+}{
+	// No corruption.
+	{"\xa1\x61a\x01\xa1\x61b\x02", "{\"a\":1}\n{\"b\":2}\n", nil},
+	// Garbage between two records.
+	{"\xa1\x61a\x01\xff\xfe\xa1\x61b\x02", "{\"a\":1}\n{\"b\":2}\n", []SkippedRange{{4, 6, nil}}},
+	// Torn record - the string claims 32 bytes but the next record starts.
+	{"\xa1\x61a\x78\x20ab\xa1\x61b\x02\xa1\x61c\x03", "{\"b\":2}\n{\"c\":3}\n", []SkippedRange{{0, 7, nil}}},
+	// Empty maps in the garbage are not plausible records.
+	{"\xa1\x61a\x01\x1c\xa0\xbf\xff\xa1\x61b\x02", "{\"a\":1}\n{\"b\":2}\n", []SkippedRange{{4, 8, nil}}},
+	// Truncated last record.
+	{"\xa1\x61a\x01\xa2\x61b\x02", "{\"a\":1}\n", []SkippedRange{{4, 8, nil}}},
+	// Two corrupted ranges.
+	{"\xffx\xa1\x61a\x01\xa1\x61b\xfc\xa1\x61c\x03", "{\"a\":1}\n{\"c\":3}\n", []SkippedRange{{0, 2, nil}, {6, 10, nil}}},
+}
+
+func TestCbor2JsonManyObjectsRecover(t *testing.T) {
+	for _, tc := range recoverTestCases {
+		buf := bytes.NewBuffer([]byte{})
+		reported := 0
+		skipped, err := Cbor2JsonManyObjectsRecover(strings.NewReader(tc.binary), buf, func(SkippedRange) { reported++ })
+		if err != nil {
+			t.Fatalf("Cbor2JsonManyObjectsRecover(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if buf.String() != tc.json {
+			t.Errorf("Cbor2JsonManyObjectsRecover(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), buf.String(), tc.json)
+		}
+		if len(skipped) != len(tc.skipped) || reported != len(tc.skipped) {
+			t.Errorf("Cbor2JsonManyObjectsRecover(0x%s) skipped %v (reported %d), want: %v", hex.EncodeToString([]byte(tc.binary)), skipped, reported, tc.skipped)
+			continue
+		}
+		for i, s := range skipped {
+			if s.Start != tc.skipped[i].Start || s.End != tc.skipped[i].End {
+				t.Errorf("Cbor2JsonManyObjectsRecover(0x%s) skipped %v, want: %v", hex.EncodeToString([]byte(tc.binary)), skipped, tc.skipped)
+			}
+			if _, ok := s.Err.(*DecodeError); !ok {
+				t.Errorf("Cbor2JsonManyObjectsRecover(0x%s) skip reason=%v, want a *DecodeError", hex.EncodeToString([]byte(tc.binary)), s.Err)
+			}
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package csd
+
+// This file contains code to decode CBOR data items into Go values of any
+// type using reflection.
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"time"
+)
+
+// Unmarshaler is implemented by types that decode themselves from CBOR.
+// UnmarshalCBOR receives the complete encoded data item; it must copy the
+// data if it keeps it after returning.
+type Unmarshaler interface {
+	UnmarshalCBOR(data []byte) error
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	timeType        = reflect.TypeOf(time.Time{})
+	ipType          = reflect.TypeOf(net.IP{})
+	hardwareAddrTyp = reflect.TypeOf(net.HardwareAddr{})
+	ipNetType       = reflect.TypeOf(net.IPNet{})
+	bigIntType      = reflect.TypeOf(big.Int{})
+	bigFloatType    = reflect.TypeOf(big.Float{})
+	decimalType     = reflect.TypeOf(Decimal{})
+)
+
+// Unmarshal decodes the CBOR data item in data into the value pointed to
+// by v, allocating maps, slices and pointers as needed:
+//
+// Maps are decoded into structs by matching keys to the name in the
+// `cbor:"name"` struct tag of the fields, or the `json` tag if there is
+// none, or the field name - an exact match is preferred over a case
+// insensitive one. Keys without a matching field are ignored.
+//
+// Timestamps (tag 1) decode into time.Time (as do RFC 3339 strings and
+// epoch based numbers), network addresses (tag 260) into net.IP or
+// net.HardwareAddr and network prefixes (tag 261) into net.IPNet.
+// Bignums decode into big.Int, decimal fractions into Decimal and
+// bigfloats into big.Float. Into an interface{}, values are decoded the
+// same way Decoder.Next decodes them.
+//
+// Null leaves values other than pointers, interfaces, maps and slices
+// unchanged. Types implementing Unmarshaler decode themselves.
+func Unmarshal(data []byte, v interface{}) error {
+	rv, err := unmarshalTarget(v)
+	if err != nil {
+		return err
+	}
+	src := newCborReader(bytes.NewReader(data), resolveOptions(nil))
+	if err := unmarshalValue(src, rv); err != nil {
+		return err
+	}
+	if h, err := peekHead(src); err == nil {
+		return src.errorf(h, "Extra data after data item")
+	}
+	return nil
+}
+
+// Decode decodes the next object of the stream into v, which must be a
+// non-nil pointer. See Unmarshal for the details. At the end of the
+// stream Decode returns io.EOF. Inside an array or map entered with Token,
+// Decode decodes the next element - at its end Decode returns an error
+// (Token returns the TokenMapEnd or TokenArrayEnd).
+func (d *Decoder) Decode(v interface{}) error {
+	rv, err := unmarshalTarget(v)
+	if err != nil {
+		return err
+	}
+	if err := d.checkValueNext(); err != nil {
+		return d.tokenError(err)
+	}
+	if _, err := d.beginValue(); err != nil {
+		return d.tokenError(err)
+	}
+	err = unmarshalValue(d.src, rv)
+	d.valueDone()
+	if err != nil {
+		return d.tokenError(err)
+	}
+	return nil
+}
+
+func unmarshalTarget(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return reflect.Value{}, fmt.Errorf("csd: Unmarshal target must be a non-nil pointer, not %T", v)
+	}
+	return rv.Elem(), nil
+}
+
+func isNull(b byte) bool {
+	return b == majorTypeSimpleAndFloat|additionalTypeNull || b == majorTypeSimpleAndFloat|additionalTypeUndefined
+}
+
+// unmarshalValue decodes the next data item into v.
+func unmarshalValue(src *cborReader, v reflect.Value) error {
+	h, err := peekHead(src)
+	if err != nil {
+		return err
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		raw, err := readRawItem(src, []byte{})
+		if err != nil {
+			return err
+		}
+		if err := v.Addr().Interface().(Unmarshaler).UnmarshalCBOR(raw); err != nil {
+			return src.wrapError(h, err)
+		}
+		return nil
+	}
+	if isNull(h.b) {
+		src.ReadByte()
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshalValue(src, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() > 0 && !v.IsNil() && v.Elem().Kind() == reflect.Ptr && !v.Elem().IsNil() {
+			return unmarshalValue(src, v.Elem())
+		}
+	case reflect.Struct:
+		if h.major() == majorTypeMap && !isSpecialStruct(v.Type()) {
+			return unmarshalStruct(src, v)
+		}
+	case reflect.Map:
+		if h.major() == majorTypeMap {
+			return unmarshalMapValue(src, v)
+		}
+	case reflect.Slice, reflect.Array:
+		if h.major() == majorTypeArray && v.Type().Elem().Kind() != reflect.Uint8 {
+			return unmarshalArrayValue(src, v)
+		}
+	}
+	g, err := unmarshalOneObject(src)
+	if err != nil {
+		return err
+	}
+	if err := assignValue(v, g); err != nil {
+		return src.wrapError(h, err)
+	}
+	return nil
+}
+
+func isSpecialStruct(t reflect.Type) bool {
+	switch t {
+	case timeType, ipNetType, bigIntType, bigFloatType, decimalType:
+		return true
+	}
+	return false
+}
+
+func unmarshalStruct(src *cborReader, v reflect.Value) error {
+	h, err := readHead(src)
+	if err != nil {
+		return err
+	}
+	if err := src.enter(h); err != nil {
+		return err
+	}
+	defer src.leave()
+	len, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return err
+	}
+	fields := cachedFields(v.Type())
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, len, unSpecifiedCount)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+		k, err := unmarshalMapKeyString(src)
+		if err != nil {
+			return err
+		}
+		f := lookupField(fields, k)
+		if f == nil {
+			_, err = readRawItem(src, nil)
+		} else {
+			err = unmarshalValue(src, fieldByIndexAlloc(v, f.index))
+		}
+		if err != nil {
+			return prependPath(err, "."+k)
+		}
+	}
+	return nil
+}
+
+func unmarshalMapValue(src *cborReader, v reflect.Value) error {
+	h, err := readHead(src)
+	if err != nil {
+		return err
+	}
+	if err := src.enter(h); err != nil {
+		return err
+	}
+	defer src.leave()
+	len, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return err
+	}
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, len, unSpecifiedCount)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+		kv := reflect.New(t.Key()).Elem()
+		switch {
+		case t.Key().Kind() == reflect.String:
+			var k string
+			if k, err = unmarshalMapKeyString(src); err == nil {
+				kv.SetString(k)
+			}
+		case t.Key().Kind() == reflect.Interface && t.Key().NumMethod() == 0:
+			var key interface{}
+			if key, err = unmarshalAnyMapKey(src); err == nil && key != nil {
+				kv.Set(reflect.ValueOf(key))
+			}
+		default:
+			err = unmarshalValue(src, kv)
+		}
+		if err != nil {
+			return err
+		}
+		ev := reflect.New(t.Elem()).Elem()
+		if err := unmarshalValue(src, ev); err != nil {
+			return prependPath(err, fmt.Sprintf(".%v", kv.Interface()))
+		}
+		v.SetMapIndex(kv, ev)
+	}
+	return nil
+}
+
+func unmarshalArrayValue(src *cborReader, v reflect.Value) error {
+	h, err := readHead(src)
+	if err != nil {
+		return err
+	}
+	if err := src.enter(h); err != nil {
+		return err
+	}
+	defer src.leave()
+	len, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return err
+	}
+	isSlice := v.Kind() == reflect.Slice
+	n := 0
+	for ; ; n++ {
+		done, err := readNextElement(src, h, n, len, unSpecifiedCount)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+		switch {
+		case isSlice && n >= v.Len():
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		case n < v.Len():
+			v.Index(n).Set(reflect.Zero(v.Type().Elem()))
+		default:
+			// Array too short - drop the element.
+			if _, err := readRawItem(src, nil); err != nil {
+				return prependPath(err, indexPath(n))
+			}
+			continue
+		}
+		if err := unmarshalValue(src, v.Index(n)); err != nil {
+			return prependPath(err, indexPath(n))
+		}
+	}
+	if !isSlice {
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+	} else if v.IsNil() {
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	} else {
+		v.SetLen(n)
+	}
+	return nil
+}
+
+// assignValue sets v to the decoded (as by Decoder.Next) value g.
+func assignValue(v reflect.Value, g interface{}) error {
+	t := v.Type()
+	if g == nil {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+	gv := reflect.ValueOf(g)
+	switch t {
+	case timeType:
+		switch x := g.(type) {
+		case string:
+			tm, err := time.Parse(time.RFC3339Nano, x)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(tm))
+			return nil
+		case int64, float64:
+			tm, _, _ := timeFromContent(x)
+			v.Set(reflect.ValueOf(tm.UTC()))
+			return nil
+		}
+	case ipType:
+		switch x := g.(type) {
+		case []byte:
+			if n := len(x); n == net.IPv4len || n == net.IPv6len {
+				v.Set(reflect.ValueOf(net.IP(x)))
+				return nil
+			}
+		case string:
+			if ip := net.ParseIP(x); ip != nil {
+				v.Set(reflect.ValueOf(ip))
+				return nil
+			}
+		}
+	case hardwareAddrTyp:
+		switch x := g.(type) {
+		case []byte:
+			v.Set(reflect.ValueOf(net.HardwareAddr(x)))
+			return nil
+		case string:
+			mac, err := net.ParseMAC(x)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(mac))
+			return nil
+		}
+	case ipNetType:
+		if x, ok := g.(string); ok {
+			_, ipNet, err := net.ParseCIDR(x)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(*ipNet))
+			return nil
+		}
+	case bigIntType:
+		if n, ok := bigIntValue(g); ok && v.CanAddr() {
+			v.Addr().Interface().(*big.Int).Set(n)
+			return nil
+		}
+	case bigFloatType:
+		if f, ok := g.(*big.Float); ok && v.CanAddr() {
+			v.Addr().Interface().(*big.Float).Set(f)
+			return nil
+		}
+		if n, ok := bigIntValue(g); ok && v.CanAddr() {
+			v.Addr().Interface().(*big.Float).SetInt(n)
+			return nil
+		}
+	case decimalType:
+		if n, ok := bigIntValue(g); ok {
+			v.Set(reflect.ValueOf(Decimal{Mantissa: n}))
+			return nil
+		}
+	}
+	if gv.Type().AssignableTo(t) {
+		v.Set(gv)
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := g.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := bigIntValue(g); ok && n.IsInt64() && !v.OverflowInt(n.Int64()) {
+			v.SetInt(n.Int64())
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := bigIntValue(g); ok && n.IsUint64() && !v.OverflowUint(n.Uint64()) {
+			v.SetUint(n.Uint64())
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch x := g.(type) {
+		case float64:
+			f = x
+		case *big.Float:
+			f, _ = x.Float64()
+		default:
+			n, ok := bigIntValue(g)
+			if !ok {
+				return unmarshalTypeError(g, t)
+			}
+			f, _ = new(big.Float).SetInt(n).Float64()
+		}
+		if t.Kind() == reflect.Float32 && !math.IsInf(f, 0) && v.OverflowFloat(f) {
+			break
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.String:
+		switch x := g.(type) {
+		case string:
+			v.SetString(x)
+			return nil
+		case []byte:
+			v.SetString(string(x))
+			return nil
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			switch x := g.(type) {
+			case []byte:
+				v.SetBytes(x)
+				return nil
+			case string:
+				v.SetBytes([]byte(x))
+				return nil
+			}
+		}
+	case reflect.Array:
+		if b, ok := g.([]byte); ok && t.Elem().Kind() == reflect.Uint8 && len(b) == v.Len() {
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+	}
+	return unmarshalTypeError(g, t)
+}
+
+// bigIntValue returns the integer value of a decoded integer or bignum.
+func bigIntValue(g interface{}) (*big.Int, bool) {
+	switch x := g.(type) {
+	case int64:
+		return big.NewInt(x), true
+	case uint64:
+		return new(big.Int).SetUint64(x), true
+	case *big.Int:
+		return x, true
+	}
+	return nil, false
+}
+
+func unmarshalTypeError(g interface{}, t reflect.Type) error {
+	return fmt.Errorf("Cannot unmarshal %T into Go value of type %s", g, t)
+}
@@ -0,0 +1,255 @@
+package csd
+
+import (
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type logCtx struct {
+	Host string `json:"host"`
+	Port uint16 `cbor:"port,omitempty" json:"ignored"`
+}
+
+type logEntry struct {
+	logCtx
+	Level   string                 `cbor:"level"`
+	Time    time.Time              `cbor:"time"`
+	Message string                 `json:"message"`
+	Fault   int                    `cbor:"Fault"`
+	IP      net.IP                 `cbor:"ip"`
+	MAC     net.HardwareAddr       `cbor:"mac"`
+	Prefix  *net.IPNet             `cbor:"pfx"`
+	Tags    []string               `cbor:"tags"`
+	Extra   map[string]interface{} `cbor:"extra"`
+	Any     interface{}            `cbor:"any"`
+	Skipped string                 `cbor:"-"`
+	private int
+}
+
+// LogSource is embedded by pointer.
+type LogSource struct {
+	File string `cbor:"file"`
+	Line int    `cbor:"line,omitempty"`
+}
+
+type sourcedEntry struct {
+	*LogSource
+	Message string `cbor:"msg"`
+}
+
+// Chain embeds a pointer to itself.
+type Chain struct {
+	*Chain
+	N int
+}
+
+// hexID decodes itself from a byte string.
+type hexID string
+
+func (id *hexID) UnmarshalCBOR(data []byte) error {
+	var b []byte
+	if err := Unmarshal(data, &b); err != nil {
+		return err
+	}
+	*id = hexID(hex.EncodeToString(b))
+	return nil
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	bin := "\xbf" +
+		"\x65level\x64info" +
+		"\x64time\xc1\x1a\x5a\xbf\x71\x8f" +
+		"\x67message\x64TCA:" +
+		"\x65fault\x19\xa2\xb2" +
+		"\x62ip\xd9\x01\x04\x44\x0a\x00\x00\x01" +
+		"\x63mac\xd9\x01\x04\x46\x12\x34\x56\x78\x90\xab" +
+		"\x63pfx\xd9\x01\x05\xa1\x44\xc0\xa8\x00\x00\x10" +
+		"\x64tags\x82\x61a\x61b" +
+		"\x65extra\xa1\x61n\x01" +
+		"\x63any\x82\x01\xf5" +
+		"\x64host\x62h1" +
+		"\x64port\x19\x1f\x90" +
+		"\x67Skipped\x61x" +
+		"\x67unknown\xa1\x61x\x9f\x01\xff" +
+		"\xff"
+	var got logEntry
+	if err := Unmarshal([]byte(bin), &got); err != nil {
+		t.Fatalf("Unmarshal(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	_, pfx, _ := net.ParseCIDR("192.168.0.0/16")
+	want := logEntry{
+		logCtx:  logCtx{Host: "h1", Port: 8080},
+		Level:   "info",
+		Time:    time.Unix(1522495887, 0).UTC(),
+		Message: "TCA:",
+		Fault:   41650,
+		IP:      net.IP{10, 0, 0, 1},
+		MAC:     net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0xab},
+		Prefix:  pfx,
+		Tags:    []string{"a", "b"},
+		Extra:   map[string]interface{}{"n": int64(1)},
+		Any:     []interface{}{int64(1), true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal(0x%s)=%+v, want: %+v", hex.EncodeToString([]byte(bin)), got, want)
+	}
+}
+
+func TestEmbeddedStructPointer(t *testing.T) {
+	bin := "\xa3\x64file\x63a.c\x64line\x07\x63msg\x61m"
+	var got sourcedEntry
+	if err := Unmarshal([]byte(bin), &got); err != nil {
+		t.Fatalf("Unmarshal(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	want := sourcedEntry{LogSource: &LogSource{File: "a.c", Line: 7}, Message: "m"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal(0x%s)=%+v, want: %+v", hex.EncodeToString([]byte(bin)), got, want)
+	}
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{want, bin},
+		{sourcedEntry{Message: "m"}, "\xa1\x63msg\x61m"},
+		{Chain{Chain: &Chain{N: 1}, N: 2}, "\xa1\x61N\x02"},
+	} {
+		got, err := Marshal(tc.v)
+		if err != nil || string(got) != tc.want {
+			t.Errorf("Marshal(%+v)=0x%s (err: %v), want: 0x%s", tc.v, hex.EncodeToString(got), err, hex.EncodeToString([]byte(tc.want)))
+		}
+	}
+	var c Chain
+	if err := Unmarshal([]byte("\xa1\x61N\x03"), &c); err != nil || c.N != 3 || c.Chain != nil {
+		t.Errorf("Unmarshal(0xa1614e03)=%+v (err: %v), want: {N:3}", c, err)
+	}
+}
+
+func TestUnmarshalTypes(t *testing.T) {
+	var (
+		i8    int8
+		u     uint
+		f32   float32
+		s     string
+		b     []byte
+		arr   [2]int
+		m     map[int]string
+		mi    map[interface{}]interface{}
+		p     *int
+		bi    big.Int
+		pbi   *big.Int
+		tm    time.Time
+		id    hexID
+		ids   []hexID
+		iface interface{}
+	)
+	var unmarshalTestCases = []struct {
+		binary string
+		v      interface{}
+		want   interface{}
+	}{
+		{"\x38\x7f", &i8, int8(-128)},
+		{"\x1b\x00\x00\x00\x01\x00\x00\x00\x00", &u, uint(1 << 32)},
+		{"\xf9\x3e\x00", &f32, float32(1.5)},
+		{"\x18\x64", &f32, float32(100)},
+		{"\x63abc", &s, "abc"},
+		{"\x42\x01\x02", &s, "\x01\x02"},
+		{"\x5f\x41\x01\x41\x02\xff", &b, []byte{1, 2}},
+		{"\x83\x01\x02\x03", &arr, [2]int{1, 2}},
+		{"\x81\x05", &arr, [2]int{5, 0}},
+		{"\xa2\x01\x61a\x20\x61b", &m, map[int]string{1: "a", -1: "b"}},
+		{"\xa2\x01\x61a\x42\x01\x02\xf5", &mi, map[interface{}]interface{}{int64(1): "a", "\x01\x02": true}},
+		{"\x18\x2a", &p, func() *int { n := 42; return &n }()},
+		{"\xf6", &p, (*int)(nil)},
+		{"\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00", &bi, *new(big.Int).Lsh(big.NewInt(1), 64)},
+		{"\x3b\xff\xff\xff\xff\xff\xff\xff\xff", &pbi, new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 64))},
+		{"\x74\x32\x30\x31\x33\x2d\x30\x33\x2d\x32\x31\x54\x32\x30\x3a\x30\x34\x3a\x30\x30\x5a", &tm, time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"\x42\xbe\xef", &id, hexID("beef")},
+		{"\x82\x41\x01\x40", &ids, []hexID{"01", ""}},
+		{"\xa1\x61a\x81\x01", &iface, map[string]interface{}{"a": []interface{}{int64(1)}}},
+	}
+	for _, tc := range unmarshalTestCases {
+		if err := Unmarshal([]byte(tc.binary), tc.v); err != nil {
+			t.Errorf("Unmarshal(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+			continue
+		}
+		got := reflect.ValueOf(tc.v).Elem().Interface()
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Unmarshal(0x%s)=%#v, want: %#v", hex.EncodeToString([]byte(tc.binary)), got, tc.want)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var (
+		i8  int8
+		u   uint
+		s   string
+		st  struct{ A []int }
+		arr []int
+	)
+	var unmarshalErrorTestCases = []struct {
+		binary string
+		v      interface{}
+		path   string
+	}{
+		{"\x18\x80", &i8, "$"},
+		{"\x20", &u, "$"},
+		{"\x01", &s, "$"},
+		{"\xa1\x61A\x82\x01\x61x", &st, "$.A[1]"},
+		{"\x82\x01", &arr, "$[1]"},
+		{"\x01\x02", &u, "$"},
+	}
+	for _, tc := range unmarshalErrorTestCases {
+		err := Unmarshal([]byte(tc.binary), tc.v)
+		if de, ok := err.(*DecodeError); !ok || de.Path != tc.path {
+			t.Errorf("Unmarshal(0x%s) error=%v, want a *DecodeError at %s", hex.EncodeToString([]byte(tc.binary)), err, tc.path)
+		}
+	}
+	if err := Unmarshal([]byte("\x01"), u); err == nil {
+		t.Errorf("Unmarshal into a non-pointer succeeded, want error")
+	}
+}
+
+func TestDecoderDecode(t *testing.T) {
+	bin := "\xa1\x65level\x64info" + "\xa1\x65level\x64warn"
+	d := NewDecoder(strings.NewReader(bin))
+	var levels []string
+	for {
+		var e logEntry
+		err := d.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Decode(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+		}
+		levels = append(levels, e.Level)
+	}
+	if !reflect.DeepEqual(levels, []string{"info", "warn"}) {
+		t.Errorf("Decode(0x%s) levels=%v, want: [info warn]", hex.EncodeToString([]byte(bin)), levels)
+	}
+
+	// Decode does not read past the end of an array entered with Token.
+	d = NewDecoder(strings.NewReader("\x81\x01\xa1\x61a\x02"))
+	d.Token()
+	var n int
+	if err := d.Decode(&n); err != nil || n != 1 {
+		t.Fatalf("Decode() of the array element=%d, %v, want: 1", n, err)
+	}
+	if err := d.Decode(&n); err == nil {
+		t.Errorf("Decode() at the end of an array succeeded, want error")
+	}
+	if tok, err := d.Token(); err != nil || tok.Kind != TokenArrayEnd {
+		t.Errorf("Token() after Decode()=%s, %v, want: ArrayEnd", tokenString(tok), err)
+	}
+	var m map[string]int
+	if err := d.Decode(&m); err != nil || m["a"] != 2 {
+		t.Errorf("Decode() of the next record=%v, %v, want: map[a:2]", m, err)
+	}
+}
@@ -0,0 +1,246 @@
+package csd
+
+// This file contains the registry of tag decoders consulted by both the
+// JSON and the unmarshal paths, along with the built-in tag decoders.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// TagJSONRenderer renders the decoded content of a tag as JSON.
+// Content is decoded the same way Decoder.Next decodes values; opts are
+// the options of the decoder (with defaults filled in). A returned error
+// is reported as a *DecodeError for the tag.
+type TagJSONRenderer func(content interface{}, opts *DecoderOptions) ([]byte, error)
+
+// TagUnmarshaler converts the decoded content of a tag to the value
+// returned by Decoder.Next. A returned error is reported as a *DecodeError
+// for the tag.
+type TagUnmarshaler func(content interface{}, opts *DecoderOptions) (interface{}, error)
+
+// TagHandler holds the functions decoding a tag. If either is nil, that
+// path falls back to the default representation of unknown tags.
+type TagHandler struct {
+	Render    TagJSONRenderer
+	Unmarshal TagUnmarshaler
+}
+
+var (
+	tagHandlersMu sync.RWMutex
+	tagHandlers   = map[uint64]TagHandler{}
+)
+
+// RegisterTag teaches csd how to decode tag number. render is used by the
+// JSON converter and unmarshal by the Decoder; if either is nil, that path
+// falls back to the default representation of unknown tags. Registering
+// both as nil removes the tag. Built-in tags can be overridden as well.
+func RegisterTag(number uint64, render TagJSONRenderer, unmarshal TagUnmarshaler) {
+	tagHandlersMu.Lock()
+	defer tagHandlersMu.Unlock()
+	if render == nil && unmarshal == nil {
+		delete(tagHandlers, number)
+		return
+	}
+	tagHandlers[number] = TagHandler{render, unmarshal}
+}
+
+func lookupTag(number uint64) (TagHandler, bool) {
+	tagHandlersMu.RLock()
+	defer tagHandlersMu.RUnlock()
+	h, ok := tagHandlers[number]
+	return h, ok
+}
+
+func init() {
+	RegisterTag(additionalTypeTimestamp, renderTimeStamp, unmarshalTimeStamp)
+	RegisterTag(additionalTypeTagPositiveBignum,
+		renderBigNumber(additionalTypeTagPositiveBignum), unmarshalBignum(additionalTypeTagPositiveBignum))
+	RegisterTag(additionalTypeTagNegativeBignum,
+		renderBigNumber(additionalTypeTagNegativeBignum), unmarshalBignum(additionalTypeTagNegativeBignum))
+	RegisterTag(additionalTypeTagDecimalFraction,
+		renderBigNumber(additionalTypeTagDecimalFraction), unmarshalDecimalFraction)
+	RegisterTag(additionalTypeTagBigfloat, renderBigNumber(additionalTypeTagBigfloat), unmarshalBigfloat)
+	RegisterTag(additionalTypeTagNetworkAddr, renderNetworkAddr, unmarshalNetworkAddr)
+	RegisterTag(additionalTypeTagNetworkPrefix, renderNetworkPrefix, unmarshalNetworkPrefix)
+	RegisterTag(additionalTypeEmbeddedJSON, renderEmbeddedJSON, unmarshalEmbeddedJSON)
+	RegisterTag(additionalTypeTagHexString, renderHexString, unmarshalHexString)
+}
+
+func quoteString(s string) []byte {
+	ss := []byte{'"'}
+	return append(append(ss, s...), '"')
+}
+
+func bytesContent(content interface{}, what string) ([]byte, error) {
+	octets, ok := content.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported %s content type: %T (expected byte string)", what, content)
+	}
+	return octets, nil
+}
+
+// timeFromContent returns the time of an epoch based timestamp (tag 1)
+// and whether it carried a fractional (float) part.
+func timeFromContent(content interface{}) (time.Time, bool, error) {
+	switch n := content.(type) {
+	case int64:
+		return time.Unix(n, 0), false, nil
+	case float64:
+		secs := int64(n)
+		n -= float64(secs)
+		n *= float64(1e9)
+		return time.Unix(secs, int64(n)), true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("TS format is neigther int nor float: %T", content)
+}
+
+func renderTimeStamp(content interface{}, opts *DecoderOptions) ([]byte, error) {
+	t, isFloat, err := timeFromContent(content)
+	if err != nil {
+		return nil, err
+	}
+	t = t.In(opts.TimeZone)
+	format := opts.IntegerTimeFormat
+	if isFloat {
+		format = opts.NanoTimeFormat
+	}
+	tsb := []byte{}
+	tsb = append(tsb, '"')
+	tsb = t.AppendFormat(tsb, format)
+	tsb = append(tsb, '"')
+	return tsb, nil
+}
+
+func unmarshalTimeStamp(content interface{}, opts *DecoderOptions) (interface{}, error) {
+	t, _, err := timeFromContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return t.In(time.UTC), nil
+}
+
+func networkAddrFromContent(content interface{}) (fmt.Stringer, error) {
+	octets, err := bytesContent(content, "Network Address")
+	if err != nil {
+		return nil, err
+	}
+	switch len(octets) {
+	case 6: // MAC address.
+		return net.HardwareAddr(octets), nil
+	case 4: // IPv4 address.
+		fallthrough
+	case 16: // IPv6 address.
+		return net.IP(octets), nil
+	}
+	return nil, fmt.Errorf("Unexpected Network Address length: %d (expected 4,6,16)", len(octets))
+}
+
+func renderNetworkAddr(content interface{}, opts *DecoderOptions) ([]byte, error) {
+	addr, err := networkAddrFromContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return quoteString(addr.String()), nil
+}
+
+func unmarshalNetworkAddr(content interface{}, opts *DecoderOptions) (interface{}, error) {
+	return networkAddrFromContent(content)
+}
+
+func networkPrefixFromContent(content interface{}) (net.IPNet, error) {
+	m, ok := content.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return net.IPNet{}, fmt.Errorf("IP Prefix is NOT of MAP of 1 elements as expected")
+	}
+	var ipPfx net.IPNet
+	for k, v := range m {
+		pfxLen, ok := v.(int64)
+		if !ok {
+			return net.IPNet{}, fmt.Errorf("Unsupported IP Prefix length type: %T", v)
+		}
+		ip := net.IP(k)
+		if len(ip) == 4 {
+			ipPfx = net.IPNet{IP: ip, Mask: net.CIDRMask(int(pfxLen), 32)}
+		} else {
+			ipPfx = net.IPNet{IP: ip, Mask: net.CIDRMask(int(pfxLen), 128)}
+		}
+	}
+	return ipPfx, nil
+}
+
+func renderNetworkPrefix(content interface{}, opts *DecoderOptions) ([]byte, error) {
+	ipPfx, err := networkPrefixFromContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return quoteString(ipPfx.String()), nil
+}
+
+func unmarshalNetworkPrefix(content interface{}, opts *DecoderOptions) (interface{}, error) {
+	return networkPrefixFromContent(content)
+}
+
+// embeddedJSONContent returns the JSON text of an embedded JSON tag,
+// which may be carried in a byte or text string.
+func embeddedJSONContent(content interface{}) ([]byte, error) {
+	if s, ok := content.(string); ok {
+		return []byte(s), nil
+	}
+	return bytesContent(content, "embedded JSON")
+}
+
+// renderEmbeddedJSON inserts valid embedded JSON (compacted, to keep each
+// record on one line) into the output. Anything else is written as a JSON
+// string holding the payload.
+func renderEmbeddedJSON(content interface{}, opts *DecoderOptions) ([]byte, error) {
+	s, err := embeddedJSONContent(content)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, s); err == nil {
+		return b.Bytes(), nil
+	}
+	ss := []byte{'"'}
+	ss = decodeStringComplex(ss, string(s), 0)
+	return append(ss, '"'), nil
+}
+
+func unmarshalEmbeddedJSON(content interface{}, opts *DecoderOptions) (interface{}, error) {
+	s, err := embeddedJSONContent(content)
+	if err != nil {
+		return nil, err
+	}
+	if opts.EmbeddedJSONAsRaw {
+		if !json.Valid(s) {
+			return nil, fmt.Errorf("Invalid embedded JSON")
+		}
+		return json.RawMessage(s), nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(s, &v); err != nil {
+		return nil, fmt.Errorf("Invalid embedded JSON: %v", err)
+	}
+	return v, nil
+}
+
+func renderHexString(content interface{}, opts *DecoderOptions) ([]byte, error) {
+	octets, err := bytesContent(content, "hex string")
+	if err != nil {
+		return nil, err
+	}
+	ss := []byte{'"'}
+	for _, v := range octets {
+		ss = append(ss, hexTable[v>>4], hexTable[v&0x0f])
+	}
+	return append(ss, '"'), nil
+}
+
+func unmarshalHexString(content interface{}, opts *DecoderOptions) (interface{}, error) {
+	return renderHexString(content, opts)
+}
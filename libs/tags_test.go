@@ -0,0 +1,136 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterTag(t *testing.T) {
+	// A private tag carrying a request ID as a byte string.
+	const requestIDTag = 80000
+	RegisterTag(requestIDTag,
+		func(content interface{}, opts *DecoderOptions) ([]byte, error) {
+			return []byte(fmt.Sprintf("\"req-%x\"", content.([]byte))), nil
+		},
+		func(content interface{}, opts *DecoderOptions) (interface{}, error) {
+			return fmt.Sprintf("req-%x", content.([]byte)), nil
+		})
+	defer RegisterTag(requestIDTag, nil, nil)
+
+	bin := "\xa1\x62id\xda\x00\x01\x38\x80\x42\xbe\xef"
+	buf := bytes.NewBuffer([]byte{})
+	err := Cbor2JsonManyObjects(getReader(bin), buf)
+	if err != nil || buf.String() != "{\"id\":\"req-beef\"}\n" {
+		t.Errorf("Cbor2JsonManyObjects(0x%s)=%s (err: %v), want: {\"id\":\"req-beef\"}", hex.EncodeToString([]byte(bin)), buf.String(), err)
+	}
+	m, err := unmarshalMap(getReader(bin))
+	if err != nil {
+		t.Fatalf("unmarshalMap(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	if m["id"] != "req-beef" {
+		t.Errorf("unmarshalMap(0x%s)=%v, want: map[id:req-beef]", hex.EncodeToString([]byte(bin)), m)
+	}
+
+	// Only an unmarshaler - JSON falls back to the unknown tag format.
+	RegisterTag(requestIDTag, nil, func(content interface{}, opts *DecoderOptions) (interface{}, error) { return "x", nil })
+	got, err := decodeTagData(getReader("\xda\x00\x01\x38\x80\x62ab"))
+	if err != nil {
+		t.Fatalf("decodeTagData(0xda00013880626162) failed: %v", err)
+	}
+	if string(got) != "{\"@tag\":80000,\"@value\":\"ab\"}" {
+		t.Errorf("decodeTagData(0xda00013880626162)=%s, want: {\"@tag\":80000,\"@value\":\"ab\"}", got)
+	}
+}
+
+func TestOverrideBuiltinTag(t *testing.T) {
+	h, _ := lookupTag(additionalTypeTagHexString)
+	defer RegisterTag(additionalTypeTagHexString, h.Render, h.Unmarshal)
+
+	RegisterTag(additionalTypeTagHexString, func(content interface{}, opts *DecoderOptions) ([]byte, error) {
+		return []byte("\"0x" + hex.EncodeToString(content.([]byte)) + "\""), nil
+	}, nil)
+	bin := "\xd9\x01\x07\x42\x12\xab"
+	got, err := decodeTagData(getReader(bin))
+	if err != nil {
+		t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	if string(got) != "\"0x12ab\"" {
+		t.Errorf("decodeTagData(0x%s)=%s, want: \"0x12ab\"", hex.EncodeToString([]byte(bin)), got)
+	}
+	v, err := unmarshalTagData(getReader(bin))
+	if err != nil {
+		t.Fatalf("unmarshalTagData(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	if tag, ok := v.(Tag); !ok || tag.Number != 263 {
+		t.Errorf("unmarshalTagData(0x%s)=%v, want: Tag{263, ...}", hex.EncodeToString([]byte(bin)), v)
+	}
+}
+
+func TestTagHandlerError(t *testing.T) {
+	const privateTag = 80001
+	RegisterTag(privateTag, func(content interface{}, opts *DecoderOptions) ([]byte, error) {
+		return nil, fmt.Errorf("bad private tag")
+	}, nil)
+	defer RegisterTag(privateTag, nil, nil)
+
+	bin := "\xa1\x61a\x82\x01\xda\x00\x01\x38\x81\x00"
+	err := Cbor2JsonManyObjects(getReader(bin), bytes.NewBuffer([]byte{}))
+	de, ok := err.(*DecodeError)
+	if !ok || de.Offset != 5 || de.Path != "$.a[1]" || de.Major != 6 || de.Err.Error() != "bad private tag" {
+		t.Errorf("Cbor2JsonManyObjects(0x%s) error=%#v, want tag error at $.a[1]", hex.EncodeToString([]byte(bin)), err)
+	}
+}
+
+func TestEmbeddedJSON(t *testing.T) {
+	var embeddedJSONTestCases = []struct {
+		binary string
+		json   string
+		want   interface{}
+	}{
+		{"\xd9\x01\x06\x47{\"a\":1}", "{\"a\":1}", map[string]interface{}{"a": float64(1)}},
+		{"\xd9\x01\x06\x67{\"a\":1}", "{\"a\":1}", map[string]interface{}{"a": float64(1)}},
+		{"\xd9\x01\x06\x4f[1, \"x\",\n null]", "[1,\"x\",null]", []interface{}{float64(1), "x", nil}},
+		{"\xd9\x01\x06\x44true", "true", true},
+		{"\xd9\x01\x06\x43\"s\"", "\"s\"", "s"},
+		{"\xd9\x01\x06\x42-5", "-5", float64(-5)},
+		// Invalid JSON is written as a string.
+		{"\xd9\x01\x06\x45{\"a\"\n", "\"{\\\"a\\\"\\n\"", nil},
+		{"\xd9\x01\x06\x40", "\"\"", nil},
+	}
+	for _, tc := range embeddedJSONTestCases {
+		got, err := decodeTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("decodeTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if string(got) != tc.json {
+			t.Errorf("decodeTagData(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), got, tc.json)
+		}
+		v, err := unmarshalTagData(getReader(tc.binary))
+		if tc.want == nil {
+			if _, ok := err.(*DecodeError); !ok {
+				t.Errorf("unmarshalTagData(0x%s)=%v, want a *DecodeError", hex.EncodeToString([]byte(tc.binary)), v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unmarshalTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if !reflect.DeepEqual(v, tc.want) {
+			t.Errorf("unmarshalTagData(0x%s)=%#v, want: %#v", hex.EncodeToString([]byte(tc.binary)), v, tc.want)
+		}
+	}
+
+	bin := "\xa1\x61j\xd9\x01\x06\x4f[1, \"x\",\n null]"
+	m, err := NewDecoder(strings.NewReader(bin), DecoderOptions{EmbeddedJSONAsRaw: true}).Next()
+	if err != nil {
+		t.Fatalf("Next(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	if raw, ok := m["j"].(json.RawMessage); !ok || string(raw) != "[1, \"x\",\n null]" {
+		t.Errorf("Next(0x%s)=%#v, want json.RawMessage", hex.EncodeToString([]byte(bin)), m)
+	}
+}
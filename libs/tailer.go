@@ -9,24 +9,79 @@ import (
 	"time"
 )
 
+// FileFollowPollInterval is the default of
+// DecoderOptions.FollowPollInterval.
 var FileFollowPollInterval = 3 * time.Second
 
+// fileWatcher wakes a follow reader when its file changes.
+type fileWatcher interface {
+	// Events delivers a value after changes to the file. It is closed
+	// when the watcher fails.
+	Events() <-chan struct{}
+	Close() error
+}
+
 type followReader struct {
-	f      *os.File
-	follow bool
-	done   chan struct{}
+	f            *os.File
+	follow       bool
+	done         chan struct{}
+	pollInterval time.Duration
+	// watcher is nil where files can not be watched, leaving only polling.
+	watcher fileWatcher
+	events  <-chan struct{}
+	// name is the file reopened by name when byName is set.
+	name     string
+	byName   bool
+	onReopen func(name string, reason string)
 }
 
-func NewFollowReader(fname string, follow bool, done chan struct{}) (*followReader, error) {
-	var err error
-	f := &followReader{}
-	f.f, err = os.Open(fname)
+// NewFollowReader opens fname for reading. If follow is set, reads at the
+// end of the file wait for more data until done is closed. If opts are
+// given, the first of them sets the poll interval and whether to follow
+// the file by name, across rotation and truncation.
+func NewFollowReader(fname string, follow bool, done chan struct{}, opts ...DecoderOptions) (*followReader, error) {
+	file, err := os.Open(fname)
 	if err != nil {
 		return nil, err
 	}
-	f.follow = follow
-	f.done = done
-	return f, nil
+	return NewFollowFile(file, follow, done, opts...), nil
+}
+
+// NewFollowFile is NewFollowReader for a file that is already open, such
+// as os.Stdin redirected from a file that is still being written.
+//
+// Anything stacked on the reader - a decompressor, the decoder - sees a
+// stream that is momentarily out of data wait rather than end, so records
+// (or compressed blocks) that are only partly written yet are decoded
+// once the rest arrives instead of failing with io.ErrUnexpectedEOF.
+func NewFollowFile(file *os.File, follow bool, done chan struct{}, opts ...DecoderOptions) *followReader {
+	o := resolveOptions(opts)
+	f := &followReader{
+		f:            file,
+		follow:       follow,
+		done:         done,
+		pollInterval: o.FollowPollInterval,
+		name:         file.Name(),
+		byName:       o.FollowByName,
+		onReopen:     o.OnFollowReopen,
+	}
+	f.watch()
+	return f
+}
+
+// watch (re)starts watching the file for changes.
+func (f *followReader) watch() {
+	if f.watcher != nil {
+		f.watcher.Close()
+		f.watcher, f.events = nil, nil
+	}
+	if !f.follow {
+		return
+	}
+	if w := newFileWatcher(f.name, f.byName); w != nil {
+		f.watcher = w
+		f.events = w.Events()
+	}
 }
 
 func (f *followReader) Read(p []byte) (int, error) {
@@ -36,14 +91,12 @@ func (f *followReader) Read(p []byte) (int, error) {
 			return n, err
 		}
 		if f.follow && err == io.EOF {
-			time.Sleep(FileFollowPollInterval)
-			cancel := false
-			select {
-			case <-f.done:
-				cancel = true
-			default:
+			if f.byName {
+				if n, err := f.reopen(p); n > 0 || err != nil {
+					return n, err
+				}
 			}
-			if cancel {
+			if !f.wait() {
 				return 0, fmt.Errorf("Cancelled Read....")
 			}
 		} else {
@@ -52,6 +105,88 @@ func (f *followReader) Read(p []byte) (int, error) {
 	}
 }
 
+// reopen checks, at the end of the file, whether it has been rotated or
+// truncated. A rotated file is read to the end - returning what was written
+// to it since the last read - before the name is reopened, a truncated one
+// starts over from offset 0.
+func (f *followReader) reopen(p []byte) (int, error) {
+	cur, err := f.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	st, err := os.Stat(f.name)
+	if err != nil {
+		// Removed, and not created again yet.
+		return 0, nil
+	}
+	if !os.SameFile(cur, st) {
+		if n, err := f.f.Read(p); n > 0 {
+			return n, nil
+		} else if err != nil && err != io.EOF {
+			return 0, err
+		}
+		file, err := os.Open(f.name)
+		if err != nil {
+			// Gone again, try later.
+			return 0, nil
+		}
+		f.f.Close()
+		f.f = file
+		f.watch()
+		f.reopened("rotated")
+		return f.readReopened(p)
+	}
+	off, err := f.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if st.Size() < off {
+		if _, err := f.f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		f.reopened("truncated")
+		return f.readReopened(p)
+	}
+	return 0, nil
+}
+
+// readReopened reads from the start of the reopened file, which may not
+// have any data yet.
+func (f *followReader) readReopened(p []byte) (int, error) {
+	n, err := f.f.Read(p)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *followReader) reopened(reason string) {
+	if f.onReopen != nil {
+		f.onReopen(f.name, reason)
+	}
+}
+
+// wait waits for the file to change, or for the poll interval to pass. It
+// returns false if done is closed first.
+func (f *followReader) wait() bool {
+	timer := time.NewTimer(f.pollInterval)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+		return false
+	case _, ok := <-f.events:
+		if !ok {
+			// The watcher failed, poll from now on.
+			f.events = nil
+		}
+	case <-timer.C:
+	}
+	return true
+}
+
 func (f *followReader) Close() {
+	if f.watcher != nil {
+		f.watcher.Close()
+	}
 	f.f.Close()
 }
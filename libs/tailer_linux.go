@@ -0,0 +1,67 @@
+package csd
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// inotifyMask selects the events that wake a follow reader: data written
+// to the file, and changes (like truncation) that may come without.
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF
+
+// inotifyDirMask selects the events in the directory of a file followed by
+// name that may bring a new file under the name.
+const inotifyDirMask = syscall.IN_CREATE | syscall.IN_MOVED_TO
+
+type inotifyWatcher struct {
+	// f is the inotify instance.
+	f      *os.File
+	events chan struct{}
+}
+
+// newFileWatcher returns an inotify watcher of the file called name - and,
+// if byName is set, of its directory - or nil if inotify is not available
+// for it.
+func newFileWatcher(name string, byName bool) fileWatcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil
+	}
+	if _, err := syscall.InotifyAddWatch(fd, name, inotifyMask); err != nil {
+		syscall.Close(fd)
+		return nil
+	}
+	if byName {
+		// Without it, a new file is only noticed by polling.
+		syscall.InotifyAddWatch(fd, filepath.Dir(name), inotifyDirMask)
+	}
+	w := &inotifyWatcher{f: os.NewFile(uintptr(fd), "inotify"), events: make(chan struct{}, 1)}
+	go w.run()
+	return w
+}
+
+// run turns inotify events into values on w.events, merging those that
+// are not received yet.
+func (w *inotifyWatcher) run() {
+	defer close(w.events)
+	buf := make([]byte, 4096)
+	for {
+		if _, err := w.f.Read(buf); err != nil {
+			return
+		}
+		select {
+		case w.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.f.Close()
+}
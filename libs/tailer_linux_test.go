@@ -0,0 +1,45 @@
+package csd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestFollowInotify checks that a follow reader wakes on a write to the
+// file long before its poll interval.
+func TestFollowInotify(t *testing.T) {
+	f, err := ioutil.TempFile("", "csd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	done := make(chan struct{})
+	defer close(done)
+	r, err := NewFollowReader(f.Name(), true, done, DecoderOptions{FollowPollInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.watcher == nil {
+		t.Skip("inotify is not available")
+	}
+	read := make(chan string, 1)
+	go func() {
+		b := make([]byte, 16)
+		n, _ := r.Read(b)
+		read <- string(b[:n])
+	}()
+	time.Sleep(20 * time.Millisecond)
+	f.Write([]byte("\xa0"))
+	select {
+	case got := <-read:
+		if got != "\xa0" {
+			t.Errorf("Read()=%q, want: %q", got, "\xa0")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Read() did not wake on the write")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package csd
+
+// newFileWatcher returns nil, files are only polled on this platform.
+func newFileWatcher(name string, byName bool) fileWatcher {
+	return nil
+}
@@ -0,0 +1,120 @@
+package csd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFollowCompressed decodes a zlib stream that is flushed in the middle
+// of records, checking that partial records wait for the rest.
+func TestFollowCompressed(t *testing.T) {
+	f, err := ioutil.TempFile("", "csd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	w := zlib.NewWriter(f)
+	w.Write([]byte("\xa1\x61x\x01\xa1"))
+	w.Flush()
+
+	done := make(chan struct{})
+	opts := DecoderOptions{FollowPollInterval: 10 * time.Millisecond}
+	r, err := NewFollowReader(f.Name(), true, done, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var out bytes.Buffer
+	result := make(chan error, 1)
+	go func() {
+		z, err := NewDecompressor(r, CompressionAuto)
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- Cbor2JsonManyObjectsOptions(z, &out, opts)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	w.Write([]byte("\x61x\x02"))
+	w.Flush()
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	if err := <-result; err == nil {
+		t.Errorf("Cbor2JsonManyObjectsOptions succeeded, want the error of the cancelled read")
+	}
+	if want := "{\"x\":1}\n{\"x\":2}\n"; out.String() != want {
+		t.Errorf("followed %q, want: %q", out.String(), want)
+	}
+}
+
+// TestFollowByName follows a file across rotation - reading what is still
+// written to the old file - and truncation.
+func TestFollowByName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "log")
+	old, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	old.Write([]byte("\xa1\x61x\x01"))
+
+	done := make(chan struct{})
+	defer close(done)
+	reasons := make(chan string, 2)
+	opts := DecoderOptions{
+		FollowPollInterval: 10 * time.Millisecond,
+		FollowByName:       true,
+		OnFollowReopen: func(name string, reason string) {
+			reasons <- reason
+		},
+	}
+	r, err := NewFollowReader(name, true, done, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	pr, pw := io.Pipe()
+	go Cbor2JsonManyObjectsOptions(r, pw, opts)
+	lines := bufio.NewScanner(pr)
+	expect := func(want string) {
+		if !lines.Scan() || lines.Text() != want {
+			t.Fatalf("followed %q, want: %q", lines.Text(), want)
+		}
+	}
+	expect("{\"x\":1}")
+
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	old.Write([]byte("\xa1\x61x\x02"))
+	time.Sleep(50 * time.Millisecond)
+	if err := ioutil.WriteFile(name, []byte("\xa1\x61x\x03"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect("{\"x\":2}")
+	expect("{\"x\":3}")
+	if got := <-reasons; got != "rotated" {
+		t.Errorf("reopened for %q, want: rotated", got)
+	}
+
+	if err := ioutil.WriteFile(name, []byte("\x04"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect("4")
+	if got := <-reasons; got != "truncated" {
+		t.Errorf("reopened for %q, want: truncated", got)
+	}
+}
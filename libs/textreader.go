@@ -0,0 +1,151 @@
+package csd
+
+// This file contains a reader that decodes CBOR written as hex or base64
+// text, as found in tickets, database dumps or JSON logs.
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+type textReader struct {
+	src *bufio.Reader
+	enc ByteEncoding
+	// off is the offset of the next character of text.
+	off int64
+	// inWord is set after the first character of a word.
+	inWord bool
+	// quantum holds the hex digits or base64 characters not decoded yet.
+	quantum []byte
+	out     []byte
+	err     error
+}
+
+// NewTextReader returns a reader of the bytes encoded as text in src, for
+// example to pass to Cbor2JsonManyObjects. enc is ByteEncodingHex,
+// ByteEncodingBase64 or ByteEncodingBase64URL.
+//
+// Whitespace and line breaks are skipped. Hex may have a 0x prefix on
+// every word and commas between words (as in "0xa1, 0x61"). Base64 may or
+// may not be padded - a word ending in the middle of a quantum is decoded
+// as unpadded - so several encoded records may follow each other.
+//
+// The text is decoded as it is read rather than loaded into memory first.
+func NewTextReader(src io.Reader, enc ByteEncoding) (io.Reader, error) {
+	switch enc {
+	case ByteEncodingHex, ByteEncodingBase64, ByteEncodingBase64URL:
+	default:
+		return nil, fmt.Errorf("csd: %s is not a text input encoding (expected hex, base64 or base64url)", enc)
+	}
+	return &textReader{src: bufio.NewReader(src), enc: enc}, nil
+}
+
+func (t *textReader) Read(p []byte) (int, error) {
+	// Fill p, but return what is decoded rather than wait for more text
+	// (when following a file).
+	for len(t.out) < len(p) && t.err == nil && (len(t.out) == 0 || t.src.Buffered() > 0) {
+		t.err = t.next()
+	}
+	n := copy(p, t.out)
+	t.out = t.out[n:]
+	if len(t.out) > 0 {
+		return n, nil
+	}
+	return n, t.err
+}
+
+func (t *textReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("csd: invalid %s input at offset %d: %s", t.enc, t.off, fmt.Sprintf(format, args...))
+}
+
+// next reads one character of text, decoding the quantum it completes.
+func (t *textReader) next() error {
+	c, err := t.src.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			if ferr := t.endWord(); ferr != nil {
+				return ferr
+			}
+			if len(t.quantum) > 0 {
+				return t.errorf("odd number of hex digits")
+			}
+		}
+		return err
+	}
+	switch {
+	case c == ' ' || c == '\t' || c == '\r' || c == '\n' || (c == ',' && t.enc == ByteEncodingHex):
+		t.off++
+		return t.endWord()
+	case c == '0' && !t.inWord && t.enc == ByteEncodingHex:
+		if b, _ := t.src.Peek(1); len(b) == 1 && (b[0] == 'x' || b[0] == 'X') {
+			t.src.ReadByte()
+			t.off += 2
+			t.inWord = true
+			return nil
+		}
+	}
+	t.inWord = true
+	if t.enc == ByteEncodingHex {
+		v := unhex(c)
+		if v > 0x0f {
+			return t.errorf("%q", c)
+		}
+		t.off++
+		t.quantum = append(t.quantum, v)
+		if len(t.quantum) == 2 {
+			t.out = append(t.out, t.quantum[0]<<4|t.quantum[1])
+			t.quantum = t.quantum[:0]
+		}
+		return nil
+	}
+	t.quantum = append(t.quantum, c)
+	t.off++
+	if len(t.quantum) == 4 {
+		return t.decodeQuantum()
+	}
+	return nil
+}
+
+// endWord decodes what is left of a base64 word as unpadded. Hex digits
+// pair up across words.
+func (t *textReader) endWord() error {
+	t.inWord = false
+	if t.enc == ByteEncodingHex || len(t.quantum) == 0 {
+		return nil
+	}
+	return t.decodeQuantum()
+}
+
+func (t *textReader) decodeQuantum() error {
+	q := t.quantum
+	for len(q) > 0 && q[len(q)-1] == '=' {
+		q = q[:len(q)-1]
+	}
+	enc := base64.RawStdEncoding
+	if t.enc == ByteEncodingBase64URL {
+		enc = base64.RawURLEncoding
+	}
+	var b [3]byte
+	n, err := enc.Decode(b[:], q)
+	if err != nil {
+		return t.errorf("%q", t.quantum)
+	}
+	t.out = append(t.out, b[:n]...)
+	t.quantum = t.quantum[:0]
+	return nil
+}
+
+// unhex returns the value of the hex digit c, or 0xff if it is not one.
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10
+	}
+	return 0xff
+}
@@ -0,0 +1,93 @@
+package csd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var textReaderTestCases = []struct {
+	enc    ByteEncoding
+	text   string
+	binary string
+}{
+	{ByteEncodingHex, "a16178", "\xa1\x61\x78"},
+	{ByteEncodingHex, "A1 61\r\n78\n", "\xa1\x61\x78"},
+	{ByteEncodingHex, "0xa161 0X78", "\xa1\x61\x78"},
+	{ByteEncodingHex, "0xa1, 0x61,0x78", "\xa1\x61\x78"},
+	{ByteEncodingHex, "a1 6\n178", "\xa1\x61\x78"},
+	{ByteEncodingHex, "00 0x00", "\x00\x00"},
+	{ByteEncodingHex, "", ""},
+	{ByteEncodingBase64, "oWF4AQ==", "\xa1\x61\x78\x01"},
+	{ByteEncodingBase64, "oWF4AQ\n", "\xa1\x61\x78\x01"},
+	{ByteEncodingBase64, "oWF4AQ== oWF4AQ\noWF4\r\nAQ", "\xa1\x61\x78\x01\xa1\x61\x78\x01\xa1\x61\x78\x01"},
+	{ByteEncodingBase64, "+/+/", "\xfb\xff\xbf"},
+	{ByteEncodingBase64URL, "-_-_ _w", "\xfb\xff\xbf\xff"},
+}
+
+func TestNewTextReader(t *testing.T) {
+	for _, tc := range textReaderTestCases {
+		r, err := NewTextReader(iotest.OneByteReader(strings.NewReader(tc.text)), tc.enc)
+		if err != nil {
+			t.Fatalf("NewTextReader(%s) failed: %v", tc.enc, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("NewTextReader(%q, %s) failed: %v", tc.text, tc.enc, err)
+			continue
+		}
+		if string(got) != tc.binary {
+			t.Errorf("NewTextReader(%q, %s)=0x%s, want: 0x%s", tc.text, tc.enc, hex.EncodeToString(got), hex.EncodeToString([]byte(tc.binary)))
+		}
+	}
+}
+
+func TestNewTextReaderRead(t *testing.T) {
+	text := strings.Repeat("0xa1 0x61 0x78 0x01\n", 100)
+	r, _ := NewTextReader(strings.NewReader(text), ByteEncodingHex)
+	p := make([]byte, 1000)
+	if n, err := r.Read(p); n != 400 || err != nil {
+		t.Errorf("Read(%d bytes) of 400 encoded=%d, %v, want: 400, <nil>", len(p), n, err)
+	}
+	r, _ = NewTextReader(iotest.OneByteReader(strings.NewReader(text)), ByteEncodingHex)
+	if n, err := r.Read(p); n != 1 || err != nil {
+		t.Errorf("Read() of text arriving by the byte=%d, %v, want: 1, <nil>", n, err)
+	}
+}
+
+func TestNewTextReaderErrors(t *testing.T) {
+	for _, tc := range []struct {
+		enc  ByteEncoding
+		text string
+	}{
+		{ByteEncodingHex, "a1g1"},
+		{ByteEncodingHex, "a16"},
+		{ByteEncodingHex, "x1"},
+		{ByteEncodingBase64, "oWF4A"},
+		{ByteEncodingBase64, "oW=4"},
+		{ByteEncodingBase64, "-_-_"},
+		{ByteEncodingBase64URL, "+/+/"},
+	} {
+		r, _ := NewTextReader(strings.NewReader(tc.text), tc.enc)
+		if got, err := ioutil.ReadAll(r); err == nil {
+			t.Errorf("NewTextReader(%q, %s)=0x%s, want an error", tc.text, tc.enc, hex.EncodeToString(got))
+		}
+	}
+	if _, err := NewTextReader(strings.NewReader(""), ByteEncodingLatin1); err == nil {
+		t.Errorf("NewTextReader(latin1) succeeded, want an error")
+	}
+}
+
+func TestNewTextReaderDecode(t *testing.T) {
+	r, _ := NewTextReader(strings.NewReader("0xa1 0x61 0x78 0x01\n0xa1 0x61 0x78 0x02\n"), ByteEncodingHex)
+	var buf bytes.Buffer
+	if err := Cbor2JsonManyObjects(r, &buf); err != nil {
+		t.Fatalf("Cbor2JsonManyObjects failed: %v", err)
+	}
+	if want := "{\"x\":1}\n{\"x\":2}\n"; buf.String() != want {
+		t.Errorf("Cbor2JsonManyObjects=%q, want: %q", buf.String(), want)
+	}
+}
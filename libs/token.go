@@ -0,0 +1,405 @@
+package csd
+
+// This file contains the streaming (pull style) token API of the Decoder.
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// TokenKind is the kind of a Token.
+type TokenKind int
+
+const (
+	// TokenMapStart and TokenArrayStart begin a map or array, which ends
+	// with a TokenMapEnd or TokenArrayEnd.
+	TokenMapStart TokenKind = iota
+	TokenMapEnd
+	TokenArrayStart
+	TokenArrayEnd
+	// TokenKey is a scalar map key, decoded the way Decoder.Next decodes
+	// it. Keys that are arrays or maps are returned as their usual tokens.
+	TokenKey
+	// TokenInt is a negative integer - an int64, or a *big.Int below
+	// math.MinInt64.
+	TokenInt
+	// TokenUint is an unsigned integer (uint64).
+	TokenUint
+	// TokenFloat is a float of any precision (float64).
+	TokenFloat
+	// TokenString and TokenBytes are a text string (string) and a byte
+	// string ([]byte). An indefinite length string is returned as a token
+	// with Length -1, one token per chunk and a TokenBreak.
+	TokenString
+	TokenBytes
+	// TokenTag is a tag number (uint64). The next value is the tag content.
+	TokenTag
+	// TokenBool is true or false.
+	TokenBool
+	// TokenNull is null or undefined.
+	TokenNull
+	// TokenBreak ends an indefinite length string.
+	TokenBreak
+)
+
+var tokenKindNames = []string{"MapStart", "MapEnd", "ArrayStart", "ArrayEnd", "Key", "Int", "Uint",
+	"Float", "String", "Bytes", "Tag", "Bool", "Null", "Break"}
+
+func (k TokenKind) String() string {
+	if k < 0 || int(k) >= len(tokenKindNames) {
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+	return tokenKindNames[k]
+}
+
+// Token is an element of the CBOR stream returned by Decoder.Token.
+type Token struct {
+	Kind TokenKind
+	// Depth is the number of arrays and maps the token is in - 0 for the
+	// top level objects.
+	Depth int
+	// Value is the value of scalar tokens and the number of tags, nil
+	// for the others.
+	Value interface{}
+	// Length is the number of elements (or pairs) of arrays and maps and
+	// the length in bytes of strings, -1 for indefinite length items.
+	Length int64
+}
+
+// tokenFrame is an array, map or indefinite length string the Token API
+// is in.
+type tokenFrame struct {
+	h head
+	// remaining is the number of items (keys and values for maps) of a
+	// definite length array or map, -1 if indefinite.
+	remaining int64
+	// items is the number of items started so far.
+	items int64
+	// key is the last key of a map, for error paths.
+	key string
+	// tags is the number of tags of the array, map or string.
+	tags int
+}
+
+func (f *tokenFrame) isString() bool {
+	return f.h.major() == majorTypeByteString || f.h.major() == majorTypeUtf8String
+}
+
+func (f *tokenFrame) endKind() TokenKind {
+	if f.h.major() == majorTypeMap {
+		return TokenMapEnd
+	}
+	return TokenArrayEnd
+}
+
+// Token returns the next token of the stream. At the end of the stream
+// Token returns io.EOF. Malformed input is reported as a *DecodeError.
+//
+// Token, Skip and Decode can be mixed freely; Next only between top level
+// objects.
+func (d *Decoder) Token() (Token, error) {
+	t, err := d.token()
+	if err != nil {
+		return Token{}, d.tokenError(err)
+	}
+	return t, nil
+}
+
+func (d *Decoder) token() (Token, error) {
+	src := d.src
+	n := len(d.stack)
+	if n == 0 {
+		if _, err := src.Peek(1); err != nil {
+			return Token{}, err
+		}
+	} else if f := &d.stack[n-1]; f.isString() {
+		return d.chunkToken(f)
+	} else if !d.tagged {
+		// The content of a tag is not the end of the array or map.
+		end, err := d.atEnd(f)
+		if err != nil {
+			return Token{}, err
+		}
+		if end {
+			kind := f.endKind()
+			d.pop()
+			return Token{Kind: kind, Depth: n - 1}, nil
+		}
+	}
+	keyPos, err := d.beginValue()
+	if err != nil {
+		return Token{}, err
+	}
+	h, err := peekHead(src)
+	if err != nil {
+		return Token{}, err
+	}
+	switch major := h.major(); {
+	case major == majorTypeTags:
+		src.ReadByte()
+		tag, err := decodeIntAdditonalType(src, h)
+		if err != nil {
+			return Token{}, err
+		}
+		if err := src.enter(h); err != nil {
+			return Token{}, err
+		}
+		d.tags++
+		d.tagged, d.taggedKey = true, keyPos
+		return Token{Kind: TokenTag, Depth: n, Value: tag}, nil
+	case keyPos && major != majorTypeArray && major != majorTypeMap:
+		k, err := unmarshalOneObject(src)
+		if err != nil {
+			return Token{}, err
+		}
+		d.stack[n-1].key = fmt.Sprint(k)
+		d.leaveTags()
+		return Token{Kind: TokenKey, Depth: n, Value: k}, nil
+	case major == majorTypeArray || major == majorTypeMap:
+		src.ReadByte()
+		if err := src.enter(h); err != nil {
+			return Token{}, err
+		}
+		len, unSpecifiedCount, err := decodeContainerLength(src, h)
+		if err != nil {
+			return Token{}, err
+		}
+		kind, f := TokenArrayStart, tokenFrame{h: h, remaining: int64(len), tags: d.tags}
+		if major == majorTypeMap {
+			kind, f.remaining = TokenMapStart, 2*int64(len)
+		}
+		t := Token{Kind: kind, Depth: n, Length: int64(len)}
+		if unSpecifiedCount {
+			f.remaining, t.Length = -1, -1
+		}
+		d.stack = append(d.stack, f)
+		d.tags = 0
+		return t, nil
+	case major == majorTypeByteString || major == majorTypeUtf8String:
+		src.ReadByte()
+		if h.minor() == additionalTypeInfiniteCount {
+			d.stack = append(d.stack, tokenFrame{h: h, remaining: -1, tags: d.tags})
+			d.tags = 0
+			return stringToken(h, []byte{}, n, -1), nil
+		}
+		length, err := decodeIntAdditonalType(src, h)
+		if err != nil {
+			return Token{}, err
+		}
+		if err := src.checkLimit(h, "MaxStringLength", length, src.limits.MaxStringLength); err != nil {
+			return Token{}, err
+		}
+		pb, err := readNBytes(src, h, length)
+		if err != nil {
+			return Token{}, err
+		}
+		d.valueDone()
+		return stringToken(h, pb, n, int64(length)), nil
+	case major == majorTypeUnsignedInt || major == majorTypeNegativeInt:
+		_, val, err := decodeIntegerFull(src)
+		if err != nil {
+			return Token{}, err
+		}
+		d.valueDone()
+		if major == majorTypeUnsignedInt {
+			return Token{Kind: TokenUint, Depth: n, Value: val}, nil
+		}
+		if val <= math.MaxInt64 {
+			return Token{Kind: TokenInt, Depth: n, Value: -1 - int64(val)}, nil
+		}
+		v := new(big.Int).SetUint64(val)
+		v.Add(v, big.NewInt(1))
+		return Token{Kind: TokenInt, Depth: n, Value: v.Neg(v)}, nil
+	}
+	if h.minor() == additionalTypeUndefined {
+		src.ReadByte()
+		d.valueDone()
+		return Token{Kind: TokenNull, Depth: n}, nil
+	}
+	v, err := unmarshalSimpleFloat(src)
+	if err != nil {
+		return Token{}, err
+	}
+	d.valueDone()
+	switch v.(type) {
+	case bool:
+		return Token{Kind: TokenBool, Depth: n, Value: v}, nil
+	case float64:
+		return Token{Kind: TokenFloat, Depth: n, Value: v}, nil
+	}
+	return Token{Kind: TokenNull, Depth: n}, nil
+}
+
+func stringToken(h head, pb []byte, depth int, length int64) Token {
+	if h.major() == majorTypeUtf8String {
+		return Token{Kind: TokenString, Depth: depth, Value: string(pb), Length: length}
+	}
+	return Token{Kind: TokenBytes, Depth: depth, Value: pb, Length: length}
+}
+
+// chunkToken returns the next chunk (or the end) of the indefinite
+// length string f.
+func (d *Decoder) chunkToken(f *tokenFrame) (Token, error) {
+	src := d.src
+	depth := len(d.stack) - 1
+	isBreak, err := readBreak(src, f.h)
+	if err != nil {
+		return Token{}, err
+	}
+	if isBreak {
+		d.tags = f.tags
+		d.stack = d.stack[:depth]
+		d.valueDone()
+		return Token{Kind: TokenBreak, Depth: depth}, nil
+	}
+	ch, err := readHead(src)
+	if err != nil {
+		return Token{}, err
+	}
+	if ch.major() != f.h.major() {
+		return Token{}, src.errorf(ch, "Major type is: %d in string chunk (expected %d)", ch.major(), f.h.major())
+	}
+	if ch.minor() == additionalTypeInfiniteCount {
+		return Token{}, src.errorf(ch, "Nested indefinite length string chunk")
+	}
+	length, err := decodeIntAdditonalType(src, ch)
+	if err != nil {
+		return Token{}, err
+	}
+	f.items += int64(length)
+	if err := src.checkLimit(f.h, "MaxStringLength", uint64(f.items), src.limits.MaxStringLength); err != nil {
+		return Token{}, err
+	}
+	pb, err := readNBytes(src, ch, length)
+	if err != nil {
+		return Token{}, err
+	}
+	return stringToken(ch, pb, depth, int64(length)), nil
+}
+
+// atEnd reports if all items of the array or map f have been read, and
+// consumes the break code of indefinite length ones.
+func (d *Decoder) atEnd(f *tokenFrame) (bool, error) {
+	if f.remaining >= 0 {
+		return f.items >= f.remaining, nil
+	}
+	return readBreak(d.src, f.h)
+}
+
+// pop leaves the array or map on top of the stack.
+func (d *Decoder) pop() {
+	d.tags = d.stack[len(d.stack)-1].tags
+	d.stack = d.stack[:len(d.stack)-1]
+	d.src.leave()
+	d.valueDone()
+}
+
+// beginValue is called before reading a value (other than the content of
+// a tag) with the Token API. It reports if the value is a map key.
+func (d *Decoder) beginValue() (bool, error) {
+	if d.tagged {
+		d.tagged = false
+		return d.taggedKey, nil
+	}
+	n := len(d.stack)
+	if n == 0 {
+		d.src.startRecord()
+		return false, nil
+	}
+	f := &d.stack[n-1]
+	f.items++
+	if f.remaining >= 0 {
+		return f.h.major() == majorTypeMap && f.items%2 == 1, nil
+	}
+	elems := f.items
+	if f.h.major() == majorTypeMap {
+		if f.items%2 == 0 {
+			return false, nil
+		}
+		elems = (f.items + 1) / 2
+	}
+	return f.h.major() == majorTypeMap, d.src.checkLimit(f.h, "MaxElements", uint64(elems), d.src.limits.MaxElements)
+}
+
+// valueDone is called when a value has been read completely.
+func (d *Decoder) valueDone() {
+	d.leaveTags()
+	if len(d.stack) == 0 && !d.tagged {
+		d.src.record++
+	}
+}
+
+// leaveTags leaves the tags of the value read.
+func (d *Decoder) leaveTags() {
+	for ; d.tags > 0; d.tags-- {
+		d.src.leave()
+	}
+}
+
+// Skip skips the next value - the one whose first token the next call to
+// Token would return - including everything nested in or tagged by it.
+// At the end of an array or map, Skip returns an error (Token returns the
+// TokenMapEnd or TokenArrayEnd), at the end of the stream io.EOF.
+func (d *Decoder) Skip() error {
+	if err := d.skip(); err != nil {
+		return d.tokenError(err)
+	}
+	return nil
+}
+
+func (d *Decoder) skip() error {
+	src := d.src
+	if err := d.checkValueNext(); err != nil {
+		return err
+	}
+	if _, err := d.beginValue(); err != nil {
+		return err
+	}
+	if _, err := readRawItem(src, nil); err != nil {
+		return err
+	}
+	d.valueDone()
+	return nil
+}
+
+// checkValueNext returns an error unless a value is next, for Skip and
+// Decode: io.EOF at the end of the stream, a DecodeError at the end of an
+// array or map (where Token returns the end) or in an indefinite length
+// string.
+func (d *Decoder) checkValueNext() error {
+	src := d.src
+	n := len(d.stack)
+	if n == 0 {
+		_, err := src.Peek(1)
+		return err
+	}
+	if d.tagged {
+		return nil
+	}
+	f := &d.stack[n-1]
+	h, err := peekHead(src)
+	if err != nil {
+		return err
+	}
+	if f.isString() || (f.remaining >= 0 && f.items >= f.remaining) ||
+		(f.remaining < 0 && h.b == majorTypeSimpleAndFloat|additionalTypeBreak) {
+		return src.errorf(h, "No value at the end of an array or map")
+	}
+	return nil
+}
+
+// tokenError adds the path of the current token to err.
+func (d *Decoder) tokenError(err error) error {
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		f := &d.stack[i]
+		switch {
+		case f.isString():
+		case f.h.major() == majorTypeArray:
+			err = prependPath(err, indexPath(int(f.items-1)))
+		case f.items%2 == 0:
+			err = prependPath(err, "."+f.key)
+		}
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package csd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// tokenString renders t as Kind(Value)@Depth.
+func tokenString(t Token) string {
+	s := t.Kind.String()
+	switch t.Kind {
+	case TokenMapStart, TokenArrayStart:
+		s += fmt.Sprintf("(%d)", t.Length)
+	case TokenMapEnd, TokenArrayEnd, TokenNull, TokenBreak:
+	default:
+		s += fmt.Sprintf("(%v)", t.Value)
+	}
+	return fmt.Sprintf("%s@%d", s, t.Depth)
+}
+
+func readTokens(d *Decoder) (string, error) {
+	var toks []string
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return strings.Join(toks, " "), nil
+		}
+		if err != nil {
+			return strings.Join(toks, " "), err
+		}
+		toks = append(toks, tokenString(t))
+	}
+}
+
+var tokenTestCases = []struct {
+	binary string
+	tokens string
+}{
+	{"\xa2\x61a\x01\x61b\x82\x20\xf5",
+		"MapStart(2)@0 Key(a)@1 Uint(1)@1 Key(b)@1 ArrayStart(2)@1 Int(-1)@2 Bool(true)@2 ArrayEnd@1 MapEnd@0"},
+	{"\xbf\x01\xf6\x82\x01\x02\x80\xff",
+		"MapStart(-1)@0 Key(1)@1 Null@1 ArrayStart(2)@1 Uint(1)@2 Uint(2)@2 ArrayEnd@1 ArrayStart(0)@1 ArrayEnd@1 MapEnd@0"},
+	{"\x7f\x62ab\x61c\xff\x43\x01\x02\x03",
+		"String()@0 String(ab)@0 String(c)@0 Break@0 Bytes([1 2 3])@0"},
+	{"\xc1\x1a\x5a\xbf\x71\x8f\xa1\xc1\x01\xfb\x3f\xf8\x00\x00\x00\x00\x00\x00",
+		"Tag(1)@0 Uint(1522495887)@0 MapStart(1)@0 Tag(1)@1 Key(1)@1 Float(1.5)@1 MapEnd@0"},
+	{"\x82\x01\xc1\x02",
+		"ArrayStart(2)@0 Uint(1)@1 Tag(1)@1 Uint(2)@1 ArrayEnd@0"},
+	{"\x3b\xff\xff\xff\xff\xff\xff\xff\xff\xf7\xf4",
+		"Int(-18446744073709551616)@0 Null@0 Bool(false)@0"},
+}
+
+func TestDecoderToken(t *testing.T) {
+	for _, tc := range tokenTestCases {
+		got, err := readTokens(NewDecoder(strings.NewReader(tc.binary)))
+		if err != nil {
+			t.Errorf("Token(0x%s) failed after %s: %v", hex.EncodeToString([]byte(tc.binary)), got, err)
+			continue
+		}
+		if got != tc.tokens {
+			t.Errorf("Token(0x%s)=%s, want: %s", hex.EncodeToString([]byte(tc.binary)), got, tc.tokens)
+		}
+	}
+}
+
+var tokenErrorTestCases = []struct {
+	binary string
+	path   string
+}{
+	{"\xa1\x61a\x82\x01", "$.a[1]"},
+	{"\x9f\x01\x5f\x41\x01\x61x", "$[1]"},
+	{"\xff", "$"},
+	{"\x81\x1c", "$[0]"},
+}
+
+func TestDecoderTokenErrors(t *testing.T) {
+	for _, tc := range tokenErrorTestCases {
+		got, err := readTokens(NewDecoder(strings.NewReader(tc.binary)))
+		if de, ok := err.(*DecodeError); !ok || de.Path != tc.path {
+			t.Errorf("Token(0x%s) returned %s, error=%v, want a *DecodeError at %s", hex.EncodeToString([]byte(tc.binary)), got, err, tc.path)
+		}
+	}
+	d := NewDecoder(strings.NewReader("\xa1\x61a"+strings.Repeat("\x81", 10)+"\x01"), DecoderOptions{Limits: &Limits{MaxDepth: 4}})
+	if _, err := readTokens(d); exceededLimit(err) != "MaxDepth" {
+		t.Errorf("Token() of deep input error=%v, want MaxDepth exceeded", err)
+	}
+	// Tags count towards MaxDepth like in Next, until their content ends.
+	for _, tc := range []struct {
+		binary string
+		limit  string
+	}{
+		{"\xa1\x61a" + strings.Repeat("\xc1", 10) + "\x01", "MaxDepth"},
+		{"\xc1\xc1\x81\x01", "MaxDepth"},
+		{"\xc1\x81\xc1\x01", "MaxDepth"},
+		{"\xa2\xc1\x01\xc1\x02\x61b\xc1\x5f\x41\x01\xff\xc1\xc1\x03", ""},
+		{"\xc1\x80\xc1\xc1\x01\xc1\x9f\xff", ""},
+	} {
+		d := NewDecoder(strings.NewReader(tc.binary), DecoderOptions{Limits: &Limits{MaxDepth: 2}})
+		if got, err := readTokens(d); exceededLimit(err) != tc.limit {
+			t.Errorf("Token(0x%s) returned %s, error=%v, want exceeded limit %q", hex.EncodeToString([]byte(tc.binary)), got, err, tc.limit)
+		}
+	}
+}
+
+func TestDecoderSkip(t *testing.T) {
+	// Pick the level of each record, skipping everything else.
+	bin := "\xa3\x63msg\xa1\x61x\x9f\x01\xff\x65level\x64info\x63num\x01" +
+		"\xc1\x01" +
+		"\xbf\x65level\x64warn\x63msg\x7f\x61a\xff\xff"
+	d := NewDecoder(strings.NewReader(bin))
+	var levels []string
+	for {
+		t0, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Token(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+		}
+		if t0.Kind == TokenTag {
+			if err := d.Skip(); err != nil {
+				t.Fatalf("Skip() of tag content failed: %v", err)
+			}
+			continue
+		}
+		for {
+			k, err := d.Token()
+			if err != nil {
+				t.Fatalf("Token(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+			}
+			if k.Kind == TokenMapEnd {
+				break
+			}
+			if k.Value != "level" {
+				if err := d.Skip(); err != nil {
+					t.Fatalf("Skip() of %v failed: %v", k.Value, err)
+				}
+				continue
+			}
+			var level string
+			if err := d.Decode(&level); err != nil {
+				t.Fatalf("Decode() of level failed: %v", err)
+			}
+			levels = append(levels, level)
+		}
+	}
+	if strings.Join(levels, ",") != "info,warn" {
+		t.Errorf("levels of 0x%s=%v, want: [info warn]", hex.EncodeToString([]byte(bin)), levels)
+	}
+
+	d = NewDecoder(strings.NewReader("\x81\x01"))
+	d.Token()
+	d.Skip()
+	if err := d.Skip(); err == nil {
+		t.Errorf("Skip() at the end of an array succeeded, want error")
+	}
+	if tok, err := d.Token(); err != nil || tok.Kind != TokenArrayEnd {
+		t.Errorf("Token() after Skip()=%s, %v, want: ArrayEnd", tokenString(tok), err)
+	}
+	if err := d.Skip(); err != io.EOF {
+		t.Errorf("Skip() at the end of the stream=%v, want: io.EOF", err)
+	}
+
+	d = NewDecoder(strings.NewReader("\x81\xc1\x01"))
+	d.Token()
+	d.Token()
+	if err := d.Skip(); err != nil {
+		t.Errorf("Skip() of the content of the last tag of an array failed: %v", err)
+	}
+	if tok, err := d.Token(); err != nil || tok.Kind != TokenArrayEnd {
+		t.Errorf("Token() after Skip()=%s, %v, want: ArrayEnd", tokenString(tok), err)
+	}
+}
@@ -3,251 +3,361 @@ package csd
 // This file contains code to decode a stream of CBOR Data into a map[string]interface{}
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"net"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
 	"time"
 )
 
-func unmarshalString(src *bufio.Reader, noQuotes bool) string {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+func unmarshalString(src *cborReader, noQuotes bool) (string, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return "", err
+	}
+	major := h.major()
 	if major != majorTypeByteString && major != majorTypeUtf8String {
-		panic(fmt.Errorf("Major type is: %d in unmarshalString", major))
+		return "", src.errorf(h, "Major type is: %d in unmarshalString", major)
 	}
 	result := []byte{}
 	if !noQuotes {
 		result = append(result, '"')
 	}
-	length := decodeIntAdditonalType(src, minor)
-	len := int(length)
-	pbs := readNBytes(src, len)
+	pbs, err := readStringData(src, h)
+	if err != nil {
+		return "", err
+	}
 	result = append(result, pbs...)
 	if noQuotes {
-		return string(result)
+		return string(result), nil
 	}
-	return string(append(result, '"'))
+	return string(append(result, '"')), nil
 }
 
-func unmarshalUTF8String(src *bufio.Reader) string {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+func unmarshalUTF8String(src *cborReader) (string, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return "", err
+	}
+	major := h.major()
 	if major != majorTypeUtf8String {
-		panic(fmt.Errorf("Major type is: %d in decodeUTF8String", major))
+		return "", src.errorf(h, "Major type is: %d in decodeUTF8String", major)
 	}
-	result := []byte{}
-	length := decodeIntAdditonalType(src, minor)
-	len := int(length)
-	pbs := readNBytes(src, len)
-	result = append(result, pbs...)
-	return string(result)
+	pbs, err := readStringData(src, h)
+	if err != nil {
+		return "", err
+	}
+	return string(pbs), nil
 }
 
-func unmarshalArray(src *bufio.Reader) []interface{} {
+func unmarshalArray(src *cborReader) ([]interface{}, error) {
 	ret := []interface{}{}
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
 	if major != majorTypeArray {
-		panic(fmt.Errorf("Major type is: %d in array2Json", major))
-	}
-	len := 0
-	unSpecifiedCount := false
-	if minor == additionalTypeInfiniteCount {
-		unSpecifiedCount = true
-	} else {
-		length := decodeIntAdditonalType(src, minor)
-		len = int(length)
-	}
-	for i := 0; unSpecifiedCount || i < len; i++ {
-		if unSpecifiedCount {
-			pb, e := src.Peek(1)
-			if e != nil {
-				panic(e)
-			}
-			if pb[0] == byte(majorTypeSimpleAndFloat|additionalTypeBreak) {
-				readByte(src)
-				break
-			}
+		return nil, src.errorf(h, "Major type is: %d in array2Json", major)
+	}
+	if err := src.enter(h); err != nil {
+		return nil, err
+	}
+	defer src.leave()
+	len, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, len, unSpecifiedCount)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+		v, err := unmarshalOneObject(src)
+		if err != nil {
+			return nil, prependPath(err, indexPath(i))
 		}
-		ret = append(ret, unmarshalOneObject(src))
+		ret = append(ret, v)
 	}
-	return ret
+	return ret, nil
 }
 
-func unmarshalMap(src *bufio.Reader) map[string]interface{} {
+func unmarshalMap(src *cborReader) (map[string]interface{}, error) {
 	ret := make(map[string]interface{})
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
 	if major != majorTypeMap {
-		panic(fmt.Errorf("Major type is: %d in map2Json", major))
-	}
-	len := 0
-	unSpecifiedCount := false
-	if minor == additionalTypeInfiniteCount {
-		unSpecifiedCount = true
-	} else {
-		length := decodeIntAdditonalType(src, minor)
-		len = int(length) * 2
-	}
-	k := ""
-	for i := 0; unSpecifiedCount || i < len; i++ {
-		if unSpecifiedCount {
-			pb, e := src.Peek(1)
-			if e != nil {
-				panic(e)
-			}
-			if pb[0] == byte(majorTypeSimpleAndFloat|additionalTypeBreak) {
-				readByte(src)
-				break
-			}
+		return nil, src.errorf(h, "Major type is: %d in map2Json", major)
+	}
+	if err := src.enter(h); err != nil {
+		return nil, err
+	}
+	defer src.leave()
+	len, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, len, unSpecifiedCount)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
 		}
-		if i%2 == 0 {
-			// Even position values are keys.
-			k = unmarshalString(src, true)
-		} else {
-			v := unmarshalOneObject(src)
-			ret[k] = v
+		k, err := unmarshalMapKeyString(src)
+		if err != nil {
+			return nil, err
 		}
+		v, err := unmarshalOneObject(src)
+		if err != nil {
+			return nil, prependPath(err, "."+k)
+		}
+		ret[k] = v
 	}
-	return ret
+	return ret, nil
 }
 
-func unmarshalTagData(src *bufio.Reader) interface{} {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
-	if major != majorTypeTags {
-		panic(fmt.Errorf("Major type is: %d in decodeTagData", major))
+// unmarshalAnyMap decodes a map keeping the decoded keys as they are.
+func unmarshalAnyMap(src *cborReader) (map[interface{}]interface{}, error) {
+	ret := make(map[interface{}]interface{})
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
 	}
-	switch minor {
-	case additionalTypeTimestamp:
-		return unmarshalTimeStamp(src)
+	major := h.major()
+	if major != majorTypeMap {
+		return nil, src.errorf(h, "Major type is: %d in map2Json", major)
+	}
+	if err := src.enter(h); err != nil {
+		return nil, err
+	}
+	defer src.leave()
+	len, unSpecifiedCount, err := decodeContainerLength(src, h)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; ; i++ {
+		done, err := readNextElement(src, h, i, len, unSpecifiedCount)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+		k, err := unmarshalAnyMapKey(src)
+		if err != nil {
+			return nil, err
+		}
+		v, err := unmarshalOneObject(src)
+		if err != nil {
+			return nil, prependPath(err, fmt.Sprintf(".%v", k))
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
 
-	// Tag value is larger than 256 (so uint16).
-	case additionalTypeIntUint16:
-		val := decodeIntAdditonalType(src, minor)
+// unmarshalAnyMapKey decodes a map key for a map[interface{}]interface{}.
+// Byte strings are converted to strings, keys that cannot be Go map keys
+// are reported as errors.
+func unmarshalAnyMapKey(src *cborReader) (interface{}, error) {
+	kh, err := peekHead(src)
+	if err != nil {
+		return nil, err
+	}
+	k, err := unmarshalOneObject(src)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := k.([]byte); ok {
+		k = string(b)
+	}
+	if !isHashable(reflect.ValueOf(k)) {
+		return nil, src.wrapError(kh, fmt.Errorf("Unsupported map key type: %T", k))
+	}
+	return k, nil
+}
 
-		switch uint16(val) {
-		case additionalTypeEmbeddedJSON:
-			pb := readByte(src)
-			dataMajor := pb & maskOutAdditionalType
-			if dataMajor != majorTypeByteString {
-				panic(fmt.Errorf("Unsupported embedded Type: %d in decodeEmbeddedJSON", dataMajor))
-			}
-			src.UnreadByte()
-			s := unmarshalString(src, true)
-			m := make(map[string]interface{})
-			err := json.Unmarshal([]byte(s), m)
-			if err != nil {
-				panic(err)
-			}
-			return m
+// unmarshalMapKeyString decodes a map key converted to a string.
+func unmarshalMapKeyString(src *cborReader) (string, error) {
+	kh, err := peekHead(src)
+	if err != nil {
+		return "", err
+	}
+	key, err := unmarshalOneObject(src)
+	if err != nil {
+		return "", err
+	}
+	k, err := mapKeyString(key)
+	if err != nil {
+		return "", src.wrapError(kh, err)
+	}
+	return k, nil
+}
 
-		case additionalTypeTagNetworkAddr:
-			octets := decodeString(src, true)
-			switch len(octets) {
-			case 6: // MAC address.
-				ha := net.HardwareAddr(octets)
-				return ha
-			case 4: // IPv4 address.
-				fallthrough
-			case 16: // IPv6 address.
-				ip := net.IP(octets)
-				return ip
-			default:
-				panic(fmt.Errorf("Unexpected Network Address length: %d (expected 4,6,16)", len(octets)))
-			}
+// mapKeyString converts a decoded map key to a string. Byte strings are
+// used as they are; numbers, booleans, null and the values of tags are
+// formatted as in JSON output.
+func mapKeyString(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case string:
+		return k, nil
+	case []byte:
+		return string(k), nil
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(k), nil
+	case int64:
+		return strconv.FormatInt(k, 10), nil
+	case uint64:
+		return strconv.FormatUint(k, 10), nil
+	case float64:
+		return strconv.FormatFloat(k, 'f', -1, 64), nil
+	case time.Time:
+		return k.Format(time.RFC3339Nano), nil
+	case fmt.Stringer:
+		return k.String(), nil
+	}
+	return "", fmt.Errorf("Unsupported map key type: %T", key)
+}
 
-		case additionalTypeTagNetworkPrefix:
-			pb := readByte(src)
-			if pb != byte(majorTypeMap|0x1) {
-				panic(fmt.Errorf("IP Prefix is NOT of MAP of 1 elements as expected"))
-			}
-			octets := decodeString(src, true)
-			val := decodeInteger(src)
-			ip := net.IP(octets)
-			var mask net.IPMask
-			pfxLen := int(val)
-			if len(octets) == 4 {
-				mask = net.CIDRMask(pfxLen, 32)
-			} else {
-				mask = net.CIDRMask(pfxLen, 128)
+// isHashable reports if v can be used as a map key.
+func isHashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	case reflect.Interface:
+		return v.IsNil() || isHashable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isHashable(v.Index(i)) {
+				return false
 			}
-			ipPfx := net.IPNet{IP: ip, Mask: mask}
-			return ipPfx
-
-		case additionalTypeTagHexString:
-			octets := decodeString(src, true)
-			ss := []byte{'"'}
-			for _, v := range octets {
-				ss = append(ss, hexTable[v>>4], hexTable[v&0x0f])
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isHashable(v.Field(i)) {
+				return false
 			}
-			return append(ss, '"')
+		}
+	}
+	return true
+}
 
-		default:
-			panic(fmt.Errorf("Unsupported Additional Tag Type: %d in decodeTagData", val))
+// unmarshalInteger returns the decoded integer as int64 if it fits,
+// otherwise as uint64 (large positive) or *big.Int (large negative).
+func unmarshalInteger(src *cborReader) (interface{}, error) {
+	h, val, err := decodeIntegerFull(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
+	if val <= math.MaxInt64 {
+		if major == majorTypeUnsignedInt {
+			return int64(val), nil
 		}
+		return -1 - int64(val), nil
+	}
+	if major == majorTypeUnsignedInt {
+		return val, nil
 	}
-	panic(fmt.Errorf("Unsupported Additional Type: %d in decodeTagData", minor))
+	n := new(big.Int).SetUint64(val)
+	n.Add(n, big.NewInt(1))
+	return n.Neg(n), nil
+}
+
+// Tag is returned by the Decoder for tags that csd does not understand.
+// Content holds the decoded tag content.
+type Tag struct {
+	Number  uint64
+	Content interface{}
 }
 
-func unmarshalTimeStamp(src *bufio.Reader) interface{} {
-	pb := readByte(src)
-	src.UnreadByte()
-	tsMajor := pb & maskOutAdditionalType
-	if tsMajor == majorTypeUnsignedInt || tsMajor == majorTypeNegativeInt {
-		n := decodeInteger(src)
-		t := time.Unix(n, 0)
-		t = t.In(time.UTC)
-		return t
-	} else if tsMajor == majorTypeSimpleAndFloat {
-		n, _ := decodeFloat(src)
-		secs := int64(n)
-		n -= float64(secs)
-		n *= float64(1e9)
-		t := time.Unix(secs, int64(n))
-		t = t.In(time.UTC)
-		return t
-	}
-	panic(fmt.Errorf("TS format is neigther int nor float: %d", tsMajor))
+func unmarshalTagData(src *cborReader) (interface{}, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
+	if major != majorTypeTags {
+		return nil, src.errorf(h, "Major type is: %d in decodeTagData", major)
+	}
+	tag, err := decodeIntAdditonalType(src, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := src.enter(h); err != nil {
+		return nil, err
+	}
+	defer src.leave()
+	// Tag handlers expect maps with string keys.
+	anyKeys := src.anyKeys
+	src.anyKeys = false
+	content, err := unmarshalOneObject(src)
+	src.anyKeys = anyKeys
+	if err != nil {
+		return nil, err
+	}
+	if th, ok := src.opts.tagHandler(tag); ok && th.Unmarshal != nil {
+		v, err := th.Unmarshal(content, src.opts)
+		if err != nil {
+			return nil, src.wrapError(h, err)
+		}
+		return v, nil
+	}
+	if _, ok := expectedConversions[tag]; ok {
+		// Only a hint for converting to text - the content is the value.
+		return content, nil
+	}
+	return Tag{Number: tag, Content: content}, nil
 }
 
-func unmarshalSimpleFloat(src *bufio.Reader) interface{} {
-	pb := readByte(src)
-	major := pb & maskOutAdditionalType
-	minor := pb & maskOutMajorType
+func unmarshalSimpleFloat(src *cborReader) (interface{}, error) {
+	h, err := readHead(src)
+	if err != nil {
+		return nil, err
+	}
+	major := h.major()
+	minor := h.minor()
 	if major != majorTypeSimpleAndFloat {
-		panic(fmt.Errorf("Major type is: %d in decodeSimpleFloat", major))
+		return nil, src.errorf(h, "Major type is: %d in decodeSimpleFloat", major)
 	}
 	switch minor {
 	case additionalTypeBoolTrue:
-		return true
+		return true, nil
 	case additionalTypeBoolFalse:
-		return false
+		return false, nil
 	case additionalTypeNull:
-		return nil
+		return nil, nil
 	case additionalTypeFloat16:
 		fallthrough
 	case additionalTypeFloat32:
 		fallthrough
 	case additionalTypeFloat64:
 		src.UnreadByte()
-		v, _ := decodeFloat(src)
-		return v
+		v, _, err := decodeFloat(src)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
 	default:
-		panic(fmt.Errorf("Invalid Additional Type: %d in decodeSimpleFloat", minor))
+		return nil, src.errorf(h, "Invalid Additional Type: %d in decodeSimpleFloat", minor)
 	}
 }
 
-func unmarshalOneObject(src *bufio.Reader) interface{} {
+func unmarshalOneObject(src *cborReader) (interface{}, error) {
 	pb, e := src.Peek(1)
 	if e != nil {
-		panic(e)
+		return nil, src.eofError(e)
 	}
 	major := (pb[0] & maskOutAdditionalType)
 
@@ -255,31 +365,28 @@ func unmarshalOneObject(src *bufio.Reader) interface{} {
 	case majorTypeUnsignedInt:
 		fallthrough
 	case majorTypeNegativeInt:
-		n := decodeInteger(src)
-		return n
+		return unmarshalInteger(src)
 
 	case majorTypeByteString:
-		s := decodeString(src, true)
-		return s
+		return decodeString(src, true)
 
 	case majorTypeUtf8String:
-		s := unmarshalUTF8String(src)
-		return s
+		return unmarshalUTF8String(src)
 
 	case majorTypeArray:
 		return unmarshalArray(src)
 
 	case majorTypeMap:
+		if src.anyKeys {
+			return unmarshalAnyMap(src)
+		}
 		return unmarshalMap(src)
 
 	case majorTypeTags:
-		s := unmarshalTagData(src)
-		return s
+		return unmarshalTagData(src)
 
 	case majorTypeSimpleAndFloat:
-		s := unmarshalSimpleFloat(src)
-		return s
+		return unmarshalSimpleFloat(src)
 	}
-	var v interface{}
-	return v
+	return nil, nil
 }
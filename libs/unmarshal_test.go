@@ -4,12 +4,45 @@ import (
 	//"bytes"
 	"encoding/hex"
 	"fmt"
+	"math"
+	"math/big"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
 
+func TestUnmarshalInteger(t *testing.T) {
+	minInt64 := big.NewInt(math.MinInt64)
+	var integerTestCases = []struct {
+		want   interface{}
+		binary string
+	}{
+		{int64(0), "\x00"},
+		{int64(-1), "\x20"},
+		{int64(math.MaxInt64), "\x1b\x7f\xff\xff\xff\xff\xff\xff\xff"},
+		{int64(math.MinInt64), "\x3b\x7f\xff\xff\xff\xff\xff\xff\xff"},
+		{uint64(math.MaxInt64 + 1), "\x1b\x80\x00\x00\x00\x00\x00\x00\x00"},
+		{uint64(math.MaxUint64), "\x1b\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{new(big.Int).Sub(minInt64, big.NewInt(1)), "\x3b\x80\x00\x00\x00\x00\x00\x00\x00"},
+		{new(big.Int).Mul(minInt64, big.NewInt(2)), "\x3b\xff\xff\xff\xff\xff\xff\xff\xff"},
+	}
+	for _, tc := range integerTestCases {
+		got, err := unmarshalOneObject(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalOneObject(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if w, ok := tc.want.(*big.Int); ok {
+			if g, ok := got.(*big.Int); !ok || g.Cmp(w) != 0 {
+				t.Errorf("unmarshalOneObject(0x%s)=%v, want: %v", hex.EncodeToString([]byte(tc.binary)), got, tc.want)
+			}
+		} else if got != tc.want {
+			t.Errorf("unmarshalOneObject(0x%s)=%v (%T), want: %v (%T)", hex.EncodeToString([]byte(tc.binary)), got, got, tc.want, tc.want)
+		}
+	}
+}
+
 func TestUnmarshalString(t *testing.T) {
 	var encodeStringTests = []struct {
 		plain  string
@@ -40,7 +73,10 @@ func TestUnmarshalString(t *testing.T) {
 	}
 
 	for _, tt := range encodeStringTests {
-		got := unmarshalUTF8String(getReader(tt.binary))
+		got, err := unmarshalUTF8String(getReader(tt.binary))
+		if err != nil {
+			t.Fatalf("unmarshalUTF8String(0x%s) failed: %v", hex.EncodeToString([]byte(tt.binary)), err)
+		}
 		if string(got) != tt.plain {
 			t.Errorf("UnmarshalString(0x%s)=%s, want:\"%s\"\n", hex.EncodeToString([]byte(tt.binary)), string(got),
 				tt.plain)
@@ -48,6 +84,40 @@ func TestUnmarshalString(t *testing.T) {
 	}
 }
 
+func TestUnmarshalIndefiniteString(t *testing.T) {
+	var indefiniteStringTests = []struct {
+		binary string
+		plain  string
+	}{
+		{"\x7f\xff", ""},
+		{"\x7f\x65strea\x64ming\xff", "streaming"},
+		{"\x7f\x78\x02IE\x62TF\xff", "IETF"},
+	}
+	for _, tt := range indefiniteStringTests {
+		got, err := unmarshalUTF8String(getReader(tt.binary))
+		if err != nil {
+			t.Fatalf("unmarshalUTF8String(0x%s) failed: %v", hex.EncodeToString([]byte(tt.binary)), err)
+		}
+		if got != tt.plain {
+			t.Errorf("unmarshalUTF8String(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tt.binary)), got, tt.plain)
+		}
+		got, err = unmarshalString(getReader(tt.binary), true)
+		if err != nil {
+			t.Fatalf("unmarshalString(0x%s) failed: %v", hex.EncodeToString([]byte(tt.binary)), err)
+		}
+		if got != tt.plain {
+			t.Errorf("unmarshalString(0x%s)=%s, want:%s", hex.EncodeToString([]byte(tt.binary)), got, tt.plain)
+		}
+	}
+	got, err := unmarshalString(getReader("\x5f\x42\x01\x02\x41\x03\xff"), true)
+	if err != nil {
+		t.Fatalf("unmarshalString(%q) failed: %v", "\x5f\x42\x01\x02\x41\x03\xff", err)
+	}
+	if got != "\x01\x02\x03" {
+		t.Errorf("unmarshalString(0x5f420102410303ff)=%s, want: 010203", hex.EncodeToString([]byte(got)))
+	}
+}
+
 func TestUnmarshalArray(t *testing.T) {
 	var integerArrayTestCases = []struct {
 		val    []int
@@ -62,7 +132,10 @@ func TestUnmarshalArray(t *testing.T) {
 			"[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24,25]"},
 	}
 	for _, tc := range integerArrayTestCases {
-		got := unmarshalArray(getReader(tc.binary))
+		got, err := unmarshalArray(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalArray(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if len(got) != len(tc.val) {
 			t.Errorf("unmarshalArray(0x%s)=%v, want: %v", hex.EncodeToString([]byte(tc.binary)), got, tc.val)
 		}
@@ -88,7 +161,10 @@ func TestUnmarshalArray(t *testing.T) {
 			[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25}},
 	}
 	for _, tc := range infiniteArrayTestCases {
-		got := unmarshalArray(getReader(tc.in))
+		got, err := unmarshalArray(getReader(tc.in))
+		if err != nil {
+			t.Fatalf("unmarshalArray(0x%s) failed: %v", hex.EncodeToString([]byte(tc.in)), err)
+		}
 		if len(got) != len(tc.out) {
 			t.Errorf("unmarshalArray(0x%s)=%v, want: %v", hex.EncodeToString([]byte(tc.in)), got, tc.out)
 		}
@@ -112,7 +188,10 @@ func TestUnmarshalArray(t *testing.T) {
 		{[]bool{true, false, false, true, false, true}, "\x86\xf5\xf4\xf4\xf5\xf4\xf5", "[true,false,false,true,false,true]"},
 	}
 	for _, tc := range booleanArrayTestCases {
-		got := unmarshalArray(getReader(tc.binary))
+		got, err := unmarshalArray(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalArray(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		for i := 0; i < len(tc.val); i++ {
 			if got[i].(bool) != tc.val[i] {
 				t.Errorf("unmarshalArray(0x%s)=%v, want: %v", hex.EncodeToString([]byte(tc.binary)), got, tc.val)
@@ -133,7 +212,10 @@ func TestUnmarshalBool(t *testing.T) {
 		{false, "\xf4", "false"},
 	}
 	for _, tc := range booleanTestCases {
-		got := unmarshalSimpleFloat(getReader(tc.binary))
+		got, err := unmarshalSimpleFloat(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalSimpleFloat(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if got != tc.val {
 			t.Errorf("unmarshalSimpleFloat(0x%s)=%v, want:%v", hex.EncodeToString([]byte(tc.binary)), got, tc.val)
 		}
@@ -154,12 +236,48 @@ func TestUnmarshalFloat(t *testing.T) {
 	}
 
 	for _, tc := range float32TestCases {
-		got := unmarshalSimpleFloat(getReader(tc.binary))
+		got, err := unmarshalSimpleFloat(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalSimpleFloat(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		g := got.(float64)
 		if g != tc.val && (g-tc.val > 0.000001 || g-tc.val < -0.000001) {
 			t.Errorf("unmarshalFloat(0x%s)=%v, want:%v delta:%v\n", hex.EncodeToString([]byte(tc.binary)), got, tc.val, g-tc.val)
 		}
 	}
+
+	var float16TestCases = []struct {
+		val    float64
+		binary string
+	}{
+		{0, "\xf9\x00\x00"},
+		{1, "\xf9\x3c\x00"},
+		{1.5, "\xf9\x3e\x00"},
+		{0.0999755859375, "\xf9\x2e\x66"},
+		{65504, "\xf9\x7b\xff"},
+		{-4, "\xf9\xc4\x00"},
+		{0.00006103515625, "\xf9\x04\x00"},
+		{5.960464477539063e-8, "\xf9\x00\x01"},
+		{math.Inf(1), "\xf9\x7c\x00"},
+		{math.Inf(-1), "\xf9\xfc\x00"},
+	}
+
+	for _, tc := range float16TestCases {
+		got, err := unmarshalSimpleFloat(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalSimpleFloat(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
+		if got.(float64) != tc.val {
+			t.Errorf("unmarshalFloat(0x%s)=%v, want:%v\n", hex.EncodeToString([]byte(tc.binary)), got, tc.val)
+		}
+	}
+	got, err := unmarshalSimpleFloat(getReader("\xf9\x7e\x00"))
+	if err != nil {
+		t.Fatalf("unmarshalSimpleFloat(%q) failed: %v", "\xf9\x7e\x00", err)
+	}
+	if !math.IsNaN(got.(float64)) {
+		t.Errorf("unmarshalFloat(0xf97e00)=%v, want: NaN", got)
+	}
 }
 
 func isSameIpAddr(p1, p2 net.IP) bool {
@@ -186,7 +304,10 @@ func TestUnmarshalNetworkAddr(t *testing.T) {
 			"\xd9\x01\x04\x50\x20\x01\x0d\xb8\x85\xa3\x00\x00\x00\x00\x8a\x2e\x03\x70\x73\x34"},
 	}
 	for _, tc := range ipAddrTestCases {
-		d1 := unmarshalTagData(getReader(tc.binary))
+		d1, err := unmarshalTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if !isSameIpAddr(d1.(net.IP), tc.ipaddr) {
 			t.Errorf("unmarshalNetworkAddr(0x%s)=%v, want:%v", hex.EncodeToString([]byte(tc.binary)), d1, tc.ipaddr)
 		}
@@ -216,7 +337,10 @@ func TestUnmarshalMACAddr(t *testing.T) {
 	}
 
 	for _, tc := range macAddrTestCases {
-		d1 := unmarshalTagData(getReader(tc.binary))
+		d1, err := unmarshalTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if !isSameMacAddr(d1.(net.HardwareAddr), tc.macaddr) {
 			t.Errorf("unmarshalNetworkAddr(0x%s)=%v, want:%v", hex.EncodeToString([]byte(tc.binary)), d1, tc.macaddr)
 		}
@@ -241,7 +365,10 @@ func TestUnmarshalIPPrefix(t *testing.T) {
 	}
 
 	for _, tc := range IPPrefixTestCases {
-		d1 := unmarshalTagData(getReader(tc.binary))
+		d1, err := unmarshalTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		if !isSameIpPrefix(d1.(net.IPNet), tc.pfx) {
 			t.Errorf("unmarshalIPPrefix(0x%s)=%v, want:%v", hex.EncodeToString([]byte(tc.binary)), d1, tc.pfx)
 		}
@@ -257,7 +384,10 @@ func TestUnmarshalTimestamp(t *testing.T) {
 		{"\xc1\x3a\x25\x71\x93\xa7", "1950-02-04T03:54:00Z"},
 	}
 	for _, tc := range timeIntegerTestcases {
-		tm := unmarshalTagData(getReader(tc.binary))
+		tm, err := unmarshalTagData(getReader(tc.binary))
+		if err != nil {
+			t.Fatalf("unmarshalTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.binary)), err)
+		}
 		want, e := time.Parse(time.RFC3339, tc.rfcStr)
 		if e != nil {
 			fmt.Println(e)
@@ -275,7 +405,10 @@ func TestUnmarshalTimestamp(t *testing.T) {
 		{"1956-01-02T15:04:05.999999-08:00", "\xc1\xfb\xc1\xba\x53\x81\x1a\x00\x00\x11"},
 	}
 	for _, tc := range timeFloatTestcases {
-		tm := unmarshalTagData(getReader(tc.out))
+		tm, err := unmarshalTagData(getReader(tc.out))
+		if err != nil {
+			t.Fatalf("unmarshalTagData(0x%s) failed: %v", hex.EncodeToString([]byte(tc.out)), err)
+		}
 		//Since we convert to float and back - it may be slightly off - so
 		//we cannot check for exact equality instead, we'll check it is
 		//very close to each other Less than a Microsecond (lets not yet do nanosec)
@@ -288,6 +421,55 @@ func TestUnmarshalTimestamp(t *testing.T) {
 	}
 }
 
+func TestUnmarshalBigNumbers(t *testing.T) {
+	bn, err := unmarshalTagData(getReader("\xc3\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"))
+	if err != nil {
+		t.Fatalf("unmarshalTagData(%q) failed: %v", "\xc3\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00", err)
+	}
+	want, _ := new(big.Int).SetString("-18446744073709551617", 10)
+	if g, ok := bn.(*big.Int); !ok || g.Cmp(want) != 0 {
+		t.Errorf("unmarshalTagData(negative bignum)=%v, want: %v", bn, want)
+	}
+
+	dec, err := unmarshalTagData(getReader("\xc4\x82\x21\x19\x6a\xb3"))
+	if err != nil {
+		t.Fatalf("unmarshalTagData(%q) failed: %v", "\xc4\x82\x21\x19\x6a\xb3", err)
+	}
+	if d, ok := dec.(Decimal); !ok || d.Exponent != -2 || d.Mantissa.Int64() != 27315 || d.String() != "273.15" {
+		t.Errorf("unmarshalTagData(decimal fraction)=%v, want: 273.15", dec)
+	}
+	if s := (Decimal{}).String(); s != "0" {
+		t.Errorf("Decimal{}.String()=%s, want: 0", s)
+	}
+
+	bf, err := unmarshalTagData(getReader("\xc5\x82\x20\x03"))
+	if err != nil {
+		t.Fatalf("unmarshalTagData(%q) failed: %v", "\xc5\x82\x20\x03", err)
+	}
+	if f, ok := bf.(*big.Float); !ok || f.Cmp(big.NewFloat(1.5)) != 0 {
+		t.Errorf("unmarshalTagData(bigfloat)=%v, want: 1.5", bf)
+	}
+}
+
+func TestUnmarshalUnknownTag(t *testing.T) {
+	got, err := unmarshalTagData(getReader("\xd9\x03\xe8\x82\x01\x02"))
+	if err != nil {
+		t.Fatalf("unmarshalTagData(%q) failed: %v", "\xd9\x03\xe8\x82\x01\x02", err)
+	}
+	tag, ok := got.(Tag)
+	if !ok || tag.Number != 1000 || !reflect.DeepEqual(tag.Content, []interface{}{int64(1), int64(2)}) {
+		t.Errorf("unmarshalTagData(0xd903e8820102)=%v, want: {1000 [1 2]}", got)
+	}
+
+	ip, err := unmarshalTagData(getReader("\xda\x00\x00\x01\x04\x44\x0a\x00\x00\x01"))
+	if err != nil {
+		t.Fatalf("unmarshalTagData(%q) failed: %v", "\xda\x00\x00\x01\x04\x44\x0a\x00\x00\x01", err)
+	}
+	if !isSameIpAddr(ip.(net.IP), net.IP{10, 0, 0, 1}) {
+		t.Errorf("unmarshalTagData(0xda00000104440a000001)=%v, want: 10.0.0.1", ip)
+	}
+}
+
 func deRef(p reflect.Value) reflect.Value {
 	if p.Kind() == reflect.Interface {
 		p = p.Elem()
@@ -381,19 +563,67 @@ var mapUnmarshalTestCases = []struct {
 
 func TestUnmarshalMap(t *testing.T) {
 	for _, tc := range mapUnmarshalTestCases {
-		got := unmarshalMap(getReader(string(tc.bin)))
+		got, err := unmarshalMap(getReader(string(tc.bin)))
+		if err != nil {
+			t.Fatalf("unmarshalMap(0x%s) failed: %v", hex.EncodeToString(tc.bin), err)
+		}
 		if !isMapSame(got, tc.want) {
 			t.Errorf("unmarshalMap(0x%s)=%v, want: %v", hex.EncodeToString(tc.bin), got, tc.want)
 		}
 	}
 	for _, tc := range infiniteMapUnmarshalTestCases {
-		got := unmarshalMap(getReader(string(tc.bin)))
+		got, err := unmarshalMap(getReader(string(tc.bin)))
+		if err != nil {
+			t.Fatalf("unmarshalMap(0x%s) failed: %v", hex.EncodeToString(tc.bin), err)
+		}
 		if !isMapSame(got, tc.want) {
 			t.Errorf("unmarshalMap(0x%s)=%v, want: %v", hex.EncodeToString(tc.bin), got, tc.want)
 		}
 	}
 }
 
+func TestUnmarshalMapKeys(t *testing.T) {
+	bin := "\xa4\x01\x61x\x20\x61y\xf5\x61z\x42\x01\x02\x61w"
+	got, err := unmarshalMap(getReader(bin))
+	if err != nil {
+		t.Fatalf("unmarshalMap(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	want := map[string]interface{}{"1": "x", "-1": "y", "true": "z", "\x01\x02": "w"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unmarshalMap(0x%s)=%v, want: %v", hex.EncodeToString([]byte(bin)), got, want)
+	}
+
+	bin = "\xa1\x82\x01\x02\x03"
+	_, err = unmarshalMap(getReader(bin))
+	if de, ok := err.(*DecodeError); !ok || de.Offset != 1 || de.Major != 4 {
+		t.Errorf("unmarshalMap(0x%s) error=%v, want unsupported key at offset 1", hex.EncodeToString([]byte(bin)), err)
+	}
+}
+
+func TestDecoderNextAny(t *testing.T) {
+	// COSE style: {1: -7, 4: h'01', "n": {true: [1]}, "p": 261({h'c0a80000': 16})}
+	bin := "\xa4\x01\x26\x04\x41\x01\x61n\xa1\xf5\x81\x01\x61p\xd9\x01\x05\xa1\x44\xc0\xa8\x00\x00\x10"
+	got, err := NewDecoder(strings.NewReader(bin)).NextAny()
+	if err != nil {
+		t.Fatalf("NextAny(0x%s) failed: %v", hex.EncodeToString([]byte(bin)), err)
+	}
+	want := map[interface{}]interface{}{
+		int64(1): int64(-7),
+		int64(4): []byte{1},
+		"n":      map[interface{}]interface{}{true: []interface{}{int64(1)}},
+		"p":      net.IPNet{IP: net.IP{192, 168, 0, 0}, Mask: net.CIDRMask(16, 32)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NextAny(0x%s)=%v, want: %v", hex.EncodeToString([]byte(bin)), got, want)
+	}
+
+	bin = "\xa1\x01\xa1\x81\x01\x02"
+	_, err = NewDecoder(strings.NewReader(bin)).NextAny()
+	if de, ok := err.(*DecodeError); !ok || de.Path != "$.1" || de.Offset != 3 {
+		t.Errorf("NextAny(0x%s) error=%v, want unsupported key at $.1", hex.EncodeToString([]byte(bin)), err)
+	}
+}
+
 var compositeCborUnmarshalTestCases = []struct {
 	binary []byte
 	want   map[string]interface{}
@@ -405,7 +635,10 @@ var compositeCborUnmarshalTestCases = []struct {
 
 func TestUnmarshalCbor2Json(t *testing.T) {
 	for _, tc := range compositeCborUnmarshalTestCases {
-		got := unmarshalMap(getReader(string(tc.binary)))
+		got, err := unmarshalMap(getReader(string(tc.binary)))
+		if err != nil {
+			t.Fatalf("unmarshalMap(0x%s) failed: %v", hex.EncodeToString(tc.binary), err)
+		}
 		if !isMapSame(got, tc.want) {
 			t.Errorf("cbor2JsonManyObjects(0x%s)=%v, want: %v", hex.EncodeToString(tc.binary), got, tc.want)
 		}
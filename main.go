@@ -1,9 +1,11 @@
 package main
 
 import (
-	"compress/zlib"
+	"bytes"
 	"flag"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"time"
@@ -12,19 +14,69 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "encode" {
+		encodeMain(os.Args[2:])
+		return
+	}
 	inFile := flag.String("in", "<stdin>", "Input File (cbor Encoded)")
 	outFile := flag.String("out", "<stdout>", "Output File to which decoded JSON will be written to (WILL overwrite if already present).")
-	compressedIn := flag.Bool("compress", false, "Use if input stream is zlib compressed")
-	follow := flag.Bool("follow", false, "tail the file (default for stdin)")
+	compression := compressFlag{csd.CompressionAuto}
+	flag.Var(&compression, "compress", "Compression of the input stream: auto, none, zlib, gzip, flate, bzip2 or lzw (-compress alone is zlib)")
+	var follow followFlag
+	flag.Var(&follow, "follow", "tail the file (default for stdin from a pipe, set it for stdin redirected from a file); -follow=name follows the file name across log rotation and truncation, as tail -F")
+	poll := flag.Duration("poll", csd.FileFollowPollInterval, "How often -follow checks for new data (with inotify on Linux, only a fallback)")
+	recoverErrs := flag.Bool("recover", false, "Skip over corrupted records instead of stopping at the first one")
+	pairs := flag.Bool("pairs", false, "Write maps with array or map keys as arrays of [key,value] pairs")
+	byteEncoding := flag.String("bytes", "base64", "Encoding of byte strings: base64, base64url, hex or latin1")
+	format := flag.String("format", "json", "Output format: json, diag (RFC 8949 diagnostic notation) or hexdump (annotated bytes)")
+	verbose := flag.Bool("verbose", false, "Report the compressed segments of the input on stderr")
+	inFormat := flag.String("in-format", "cbor", "Input format: cbor, diag (diagnostic notation, read to the end before decoding), hex, base64 or base64url")
 
 	flag.Parse()
+	if flag.NArg() > 0 {
+		log.Fatalf("unexpected argument %q (-compress and -follow take values as -compress=gzip or -follow=name)", flag.Arg(0))
+	}
 
-	csd.DecodeTimeZone, _ = time.LoadLocation("America/Los_Angeles")
+	opts := csd.DecoderOptions{}
+	opts.TimeZone, _ = time.LoadLocation("America/Los_Angeles")
+	opts.ComplexKeysAsPairs = *pairs
+	if *poll <= 0 {
+		log.Fatal("-poll has to be positive")
+	}
+	opts.FollowPollInterval = *poll
+	if follow.mode == "name" {
+		if *inFile == "<stdin>" {
+			log.Fatal("-follow=name needs -in")
+		}
+		opts.FollowByName = true
+		opts.OnFollowReopen = func(name string, reason string) {
+			log.Printf("%s was %s, following it from the start", name, reason)
+		}
+	}
+	var err error
+	opts.ByteEncoding, err = csd.ParseByteEncoding(*byteEncoding)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch *format {
+	case "json":
+	case "diag", "hexdump":
+		if *recoverErrs {
+			log.Fatal("-recover is only supported with -format json")
+		}
+	default:
+		log.Fatalf("unknown -format %q", *format)
+	}
+	switch *inFormat {
+	case "cbor", "diag", "hex", "base64", "base64url":
+	default:
+		log.Fatalf("unknown -in-format %q", *inFormat)
+	}
 	var in io.Reader = os.Stdin
 	var out io.Writer = os.Stdout
 	ch := make(chan struct{})
 	if *inFile != "<stdin>" {
-		f, err := csd.NewFollowReader(*inFile, *follow, ch)
+		f, err := csd.NewFollowReader(*inFile, follow.mode != "", ch, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -32,9 +84,24 @@ func main() {
 		defer func() {
 			f.Close()
 		}()
+	} else if follow.mode != "" {
+		in = csd.NewFollowFile(os.Stdin, true, ch, opts)
+	}
+	switch *inFormat {
+	case "hex", "base64", "base64url":
+		enc, _ := csd.ParseByteEncoding(*inFormat)
+		if in, err = csd.NewTextReader(in, enc); err != nil {
+			log.Fatal(err)
+		}
 	}
-	if *compressedIn {
-		zin, err := zlib.NewReader(in)
+	if compression.c != csd.CompressionNone {
+		var onSegment func(csd.Segment)
+		if *verbose {
+			onSegment = func(s csd.Segment) {
+				log.Print(s)
+			}
+		}
+		zin, err := csd.NewDecompressorSegments(in, compression.c, onSegment)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -43,6 +110,17 @@ func main() {
 			zin.Close()
 		}()
 	}
+	if *inFormat == "diag" {
+		text, err := ioutil.ReadAll(in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := csd.Diag2Cbor(text)
+		if err != nil {
+			log.Fatal(err)
+		}
+		in = bytes.NewReader(b)
+	}
 	if *outFile != "<stdout>" {
 		f, err := os.OpenFile(*outFile, os.O_RDWR|os.O_CREATE, 0644)
 		if err != nil {
@@ -53,5 +131,92 @@ func main() {
 			f.Close()
 		}()
 	}
-	csd.Cbor2JsonManyObjects(in, out)
+	switch *format {
+	case "diag":
+		if err := csd.Cbor2DiagManyObjects(in, out, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "hexdump":
+		if err := csd.Cbor2HexdumpManyObjects(in, out, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *recoverErrs {
+		skipped, err := csd.Cbor2JsonManyObjectsRecover(in, out, func(s csd.SkippedRange) {
+			log.Printf("skipped %s", s)
+		}, opts)
+		if len(skipped) > 0 {
+			var total int64
+			for _, s := range skipped {
+				total += s.End - s.Start
+			}
+			log.Printf("skipped %d bytes in %d corrupted range(s):", total, len(skipped))
+			for _, s := range skipped {
+				log.Printf("  %d-%d", s.Start, s.End)
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := csd.Cbor2JsonManyObjectsOptions(in, out, opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// compressFlag is the -compress flag. It is still accepted without a value,
+// meaning zlib, as it used to be a boolean flag.
+type compressFlag struct {
+	c csd.Compression
+}
+
+func (f *compressFlag) String() string {
+	return f.c.String()
+}
+
+func (f *compressFlag) Set(s string) (err error) {
+	switch s {
+	case "true":
+		f.c = csd.CompressionZlib
+	case "false":
+		f.c = csd.CompressionNone
+	default:
+		f.c, err = csd.ParseCompression(s)
+	}
+	return err
+}
+
+func (f *compressFlag) IsBoolFlag() bool {
+	return true
+}
+
+// followFlag is the -follow flag: "" (off), "descriptor" (the open file -
+// also -follow alone, as it used to be a boolean flag) or "name".
+type followFlag struct {
+	mode string
+}
+
+func (f *followFlag) String() string {
+	return f.mode
+}
+
+func (f *followFlag) Set(s string) error {
+	switch s {
+	case "true", "descriptor":
+		f.mode = "descriptor"
+	case "false":
+		f.mode = ""
+	case "name":
+		f.mode = "name"
+	default:
+		return fmt.Errorf("expected descriptor or name")
+	}
+	return nil
+}
+
+func (f *followFlag) IsBoolFlag() bool {
+	return true
 }